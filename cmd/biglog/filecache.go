@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"sync"
+
+	"github.com/tm-LBenson/big-log-viewer/internal/indexer"
+)
+
+var (
+	// recentFilesMax and recentFilesBytes bound the files kept indexed
+	// after their handle lets go of them, by count and by index memory.
+	recentFilesMax         = 4
+	recentFilesBytes int64 = 256 << 20
+)
+
+// recentKey identifies the exact file a parked File indexed.
+type recentKey struct {
+	path    string
+	size    int64
+	modTime int64
+	opts    indexer.OpenOptions
+}
+
+type recentFile struct {
+	key   recentKey
+	file  *indexer.File
+	bytes int64
+}
+
+var (
+	recentMu sync.Mutex
+	// recent is ordered from least to most recently parked.
+	recent []recentFile
+)
+
+func recentKeyFor(path string, opts indexer.OpenOptions) (recentKey, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return recentKey{}, false
+	}
+	return recentKey{path, info.Size(), info.ModTime().UnixNano(), opts}, true
+}
+
+// parkFile keeps f, which no handle uses any more, so opening the same file
+// again skips indexing. f is closed instead when caching is off or it no
+// longer matches the file on disk.
+func parkFile(f *indexer.File) {
+	change, err := f.Check()
+	key, ok := recentKeyFor(f.Path, f.Options)
+	bytes := f.IndexBytes()
+	if err != nil || change != indexer.Unchanged || !ok || recentFilesMax <= 0 || bytes > recentFilesBytes {
+		_ = f.Close()
+		return
+	}
+	recentMu.Lock()
+	defer recentMu.Unlock()
+	for i, rf := range recent {
+		if rf.key == key {
+			_ = rf.file.Close()
+			recent = append(recent[:i], recent[i+1:]...)
+			break
+		}
+	}
+	recent = append(recent, recentFile{key, f, bytes})
+	total := int64(0)
+	for _, rf := range recent {
+		total += rf.bytes
+	}
+	for len(recent) > recentFilesMax || total > recentFilesBytes {
+		_ = recent[0].file.Close()
+		total -= recent[0].bytes
+		recent = recent[1:]
+	}
+}
+
+// takeRecentFile returns the parked File for path opened with opts, if the
+// file on disk is still the one it indexed. The caller owns the result.
+func takeRecentFile(path string, opts indexer.OpenOptions) *indexer.File {
+	key, ok := recentKeyFor(path, opts)
+	if !ok {
+		return nil
+	}
+	recentMu.Lock()
+	var f *indexer.File
+	for i, rf := range recent {
+		if rf.key == key {
+			f = rf.file
+			recent = append(recent[:i], recent[i+1:]...)
+			break
+		}
+	}
+	recentMu.Unlock()
+	if f == nil {
+		return nil
+	}
+	if change, err := f.Check(); err != nil || change != indexer.Unchanged {
+		_ = f.Close()
+		return nil
+	}
+	return f
+}
+
+func closeRecentFiles() {
+	recentMu.Lock()
+	defer recentMu.Unlock()
+	for _, rf := range recent {
+		_ = rf.file.Close()
+	}
+	recent = nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/tm-LBenson/big-log-viewer/internal/indexer"
+)
+
+// fileChangeHeader is set on responses read from a file that changed on
+// disk since it was indexed, to one of the indexer.Change values.
+const fileChangeHeader = "X-Biglog-File-Change"
+
+type fileStateResp struct {
+	Path   string `json:"path"`
+	Change string `json:"change"`
+	Stale  bool   `json:"stale"`
+	Lines  int    `json:"lines"`
+	Size   int64  `json:"size"`
+	Mode   string `json:"mode"`
+}
+
+type fileChangedResp struct {
+	Error  string `json:"error"`
+	Change string `json:"change"`
+}
+
+// checkFile compares f with the file on disk. Any change is reported in
+// fileChangeHeader. When the index no longer matches the data it answers
+// 409 instead of serving garbled rows, unless the client asked to keep
+// reading the old index with stale=1. Call it with mu held.
+func checkFile(w http.ResponseWriter, r *http.Request, f *indexer.File) bool {
+	change, err := f.Check()
+	if err != nil {
+		log.Printf("checking %s for changes: %v", f.Path, err)
+		return true
+	}
+	if change == indexer.Unchanged {
+		return true
+	}
+	w.Header().Set(fileChangeHeader, string(change))
+	if !change.Stale() || r.URL.Query().Get("stale") == "1" {
+		return true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	_ = json.NewEncoder(w).Encode(fileChangedResp{Error: "the file changed on disk; reopen it", Change: string(change)})
+	return false
+}
+
+// fileState reports whether the open file changed on disk. "appended" can
+// be picked up with /api/follow, "truncated" and "rewritten" need the file
+// reopened, and "replaced" or "deleted" leave the open copy readable.
+func fileState(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	defer mu.RUnlock()
+	f, err := fileFor(r)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	change, err := f.Check()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := fileStateResp{
+		Path:   f.Path,
+		Change: string(change),
+		Stale:  change.Stale(),
+		Lines:  f.Lines,
+		Size:   f.Size,
+		Mode:   f.Mode,
+	}
+	if change == indexer.Unchanged {
+		resp.Change = "unchanged"
+	}
+	writeJSON(w, resp)
+}
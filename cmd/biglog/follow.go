@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tm-LBenson/big-log-viewer/internal/indexer"
+)
+
+const followDefaultInterval = time.Second
+const followMinInterval = 250 * time.Millisecond
+const followMaxRows = 1000
+
+type followEvent struct {
+	Type  string   `json:"type"`
+	From  int      `json:"from"`
+	Lines int      `json:"lines"`
+	Size  int64    `json:"size"`
+	Mode  string   `json:"mode"`
+	Rows  []string `json:"rows,omitempty"`
+
+	Continued []int `json:"continued,omitempty"`
+	Binary    []int `json:"binary,omitempty"`
+	Records   int   `json:"records,omitempty"`
+}
+
+func followFile(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	f, err := fileFor(r)
+	mu.RUnlock()
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	if f.Compressed {
+		http.Error(w, "follow mode is not available for compressed files", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	interval := time.Duration(atoi(r.URL.Query().Get("interval"))) * time.Millisecond
+	if interval <= 0 {
+		interval = followDefaultInterval
+	}
+	if interval < followMinInterval {
+		interval = followMinInterval
+	}
+	withRows := r.URL.Query().Get("rows") == "1"
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	mu.RLock()
+	mode := f.Mode
+	mu.RUnlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// Sources that announce new data are checked as soon as they do; the
+		// ticker still runs so pings keep the stream alive.
+		var changes <-chan struct{}
+		mu.RLock()
+		if n, ok := f.Src.(indexer.Notifier); ok {
+			changes = n.Changes()
+		}
+		mu.RUnlock()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		case <-changes:
+		}
+		ev, next, changed, err := followStep(r.Context(), f, mode, withRows)
+		if err != nil {
+			writeEvent(w, "error", map[string]string{"error": err.Error()})
+			flusher.Flush()
+			return
+		}
+		f = next
+		if !changed {
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+			continue
+		}
+		mode = ev.Mode
+		writeEvent(w, ev.Type, ev)
+		flusher.Flush()
+		if ev.Type == "closed" {
+			return
+		}
+	}
+}
+
+// followStep checks f for growth. mode is the mode the client last saw, so
+// a switch made in the meantime, such as a finished background line count,
+// is reported as a reset even when the file did not grow.
+func followStep(ctx context.Context, f *indexer.File, mode string, withRows bool) (followEvent, *indexer.File, bool, error) {
+	ev, changed, err := followExtend(f, mode, withRows)
+	if errors.Is(err, indexer.ErrFileReplaced) {
+		return followReopen(ctx, f)
+	}
+	return ev, f, changed, err
+}
+
+// followReopen indexes the file that replaced f outside the lock, as an
+// open does, and swaps it into f's handle if that is still open.
+func followReopen(ctx context.Context, f *indexer.File) (followEvent, *indexer.File, bool, error) {
+	mu.RLock()
+	path := f.Path
+	pattern := ""
+	if f.Records != nil {
+		pattern = f.Records.Pattern
+	}
+	mu.RUnlock()
+
+	opts, _ := openOptionsFor(path, nil)
+	nf, err := indexer.OpenWith(ctx, path, opts, nil)
+	if err != nil {
+		return followEvent{}, f, false, err
+	}
+	ev := followEvent{
+		Type:  "reset",
+		Lines: nf.Lines,
+		Size:  nf.Size,
+		Mode:  nf.Mode,
+	}
+	// Keep record mode across rotation when the new file supports it.
+	if pattern != "" && nf.Mode == indexer.ModeLine {
+		if recs, err := nf.ScanRecords(ctx, pattern); err == nil {
+			_ = nf.SetRecords(recs)
+			ev.Records = recs.Len()
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	h := handleOf(f)
+	if h == nil {
+		nf.Close()
+		return followEvent{Type: "closed"}, f, true, nil
+	}
+	h.replace(nf)
+	return ev, nf, true, nil
+}
+
+// followExtend indexes what was appended to f. It returns
+// indexer.ErrFileReplaced, having changed nothing, when f was truncated or
+// replaced. The appended bytes are scanned outside the lock and the result
+// swapped in under it, as followReopen does.
+func followExtend(f *indexer.File, mode string, withRows bool) (followEvent, bool, error) {
+	mu.RLock()
+	h := handleOf(f)
+	if h == nil {
+		mu.RUnlock()
+		return followEvent{Type: "closed"}, true, nil
+	}
+	h.touch()
+	x, err := f.NewExtension()
+	mu.RUnlock()
+	if err != nil {
+		return followEvent{}, false, err
+	}
+	if err := x.Run(); err != nil {
+		return followEvent{}, false, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if handleOf(f) == nil {
+		return followEvent{Type: "closed"}, true, nil
+	}
+	oldSize := f.Size
+	growth, err := f.ApplyExtension(x)
+	if err != nil {
+		return followEvent{}, false, err
+	}
+	if growth.Size == oldSize && growth.Mode == mode {
+		return followEvent{}, false, nil
+	}
+
+	ev := followEvent{
+		Type:  "append",
+		From:  growth.From,
+		Lines: growth.Lines,
+		Size:  growth.Size,
+		Mode:  growth.Mode,
+	}
+	if growth.Mode != mode {
+		ev.Type = "reset"
+		if growth.Mode == indexer.ModeByte {
+			startLineCount(f)
+		}
+	}
+	if f.Records != nil {
+		ev.Records = f.Records.Len()
+	}
+	if withRows && ev.Type == "append" {
+		count := growth.Lines - growth.From
+		if count > followMaxRows {
+			count = followMaxRows
+		}
+		rows, err := f.SliceRows(growth.From, count)
+		if err != nil {
+			return followEvent{}, false, err
+		}
+		split := splitRows(rows, growth.From)
+		ev.Rows, ev.Continued, ev.Binary = split.Lines, split.Continued, split.Binary
+	}
+	return ev, true, nil
+}
+
+func writeEvent(w http.ResponseWriter, name string, v any) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/tm-LBenson/big-log-viewer/internal/indexer"
+)
+
+// newHandleParam asks /api/open for a fresh handle instead of reopening the
+// default one.
+const newHandleParam = "new"
+
+var (
+	// handleIdleTimeout closes handles nobody has used for this long. The
+	// default handle is kept, as it was before handles existed.
+	handleIdleTimeout = 30 * time.Minute
+	// maxHandles caps how many files are open at once; opening one more
+	// closes the least recently used.
+	maxHandles = 8
+)
+
+var (
+	errNoFile         = errors.New("no file")
+	errHandleNotFound = errors.New("handle not found; it was closed or expired, open the file again")
+)
+
+// fileHandle is one open file. Clients name it with ?handle=; requests
+// without one use the default handle, the one /api/open last filled without
+// a handle param.
+type fileHandle struct {
+	ID   string
+	file *indexer.File
+	used atomic.Int64
+}
+
+// handles and defaultHandle are guarded by mu.
+var (
+	handles       = map[string]*fileHandle{}
+	defaultHandle *fileHandle
+)
+
+func (h *fileHandle) touch() {
+	h.used.Store(time.Now().UnixNano())
+}
+
+func (h *fileHandle) idle(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, h.used.Load()))
+}
+
+// replace swaps in f for the handle's file and parks the old one.
+func (h *fileHandle) replace(f *indexer.File) {
+	if h.file != nil && h.file != f {
+		stopLineCount(h.file)
+		parkFile(h.file)
+	}
+	h.file = f
+	h.touch()
+	startLineCount(f)
+}
+
+// installFile puts f in the handle target names: the default handle for "",
+// a fresh handle for newHandleParam, and otherwise the handle with that ID,
+// or a fresh one if it has gone. Call it with mu held.
+func installFile(target string, f *indexer.File) *fileHandle {
+	var h *fileHandle
+	switch target {
+	case "":
+		h = defaultHandle
+	case newHandleParam:
+	default:
+		h = handles[target]
+	}
+	if h == nil {
+		for len(handles) >= maxHandles && evictHandle() {
+		}
+		h = &fileHandle{ID: newOpaqueID()}
+		handles[h.ID] = h
+	}
+	h.replace(f)
+	if target == "" {
+		defaultHandle = h
+	}
+	return h
+}
+
+// evictHandle closes the least recently used handle.
+func evictHandle() bool {
+	var oldest *fileHandle
+	for _, h := range handles {
+		if oldest == nil || h.used.Load() < oldest.used.Load() {
+			oldest = h
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	closeHandle(oldest)
+	return true
+}
+
+func closeHandle(h *fileHandle) {
+	stopLineCount(h.file)
+	parkFile(h.file)
+	delete(handles, h.ID)
+	if defaultHandle == h {
+		defaultHandle = nil
+	}
+}
+
+func closeAllHandles() {
+	for _, h := range handles {
+		closeHandle(h)
+	}
+}
+
+// handleOf returns the handle f is open in, or nil once f was closed or
+// replaced. Call it with mu held.
+func handleOf(f *indexer.File) *fileHandle {
+	for _, h := range handles {
+		if h.file == f {
+			return h
+		}
+	}
+	return nil
+}
+
+// fileFor returns the file r names with ?handle=, or the default handle's
+// file. Call it with mu held.
+func fileFor(r *http.Request) (*indexer.File, error) {
+	h := defaultHandle
+	if id := r.URL.Query().Get("handle"); id != "" {
+		h = handles[id]
+		if h == nil {
+			return nil, errHandleNotFound
+		}
+	}
+	if h == nil {
+		return nil, errNoFile
+	}
+	h.touch()
+	return h.file, nil
+}
+
+func fileError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errHandleNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// expireHandles closes idle handles once a minute.
+func expireHandles() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		mu.Lock()
+		sweepHandles(now)
+		mu.Unlock()
+	}
+}
+
+func sweepHandles(now time.Time) {
+	for _, h := range handles {
+		if h != defaultHandle && h.idle(now) > handleIdleTimeout {
+			closeHandle(h)
+		}
+	}
+}
+
+// closeFile is /api/close: it closes the file open in ?handle=, or in the
+// default handle.
+func closeFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "POST or DELETE only", http.StatusMethodNotAllowed)
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	h := defaultHandle
+	if id := r.URL.Query().Get("handle"); id != "" {
+		h = handles[id]
+	}
+	if h == nil {
+		fileError(w, errHandleNotFound)
+		return
+	}
+	closeHandle(h)
+	w.WriteHeader(http.StatusNoContent)
+}
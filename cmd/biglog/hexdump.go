@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/tm-LBenson/big-log-viewer/internal/indexer"
+)
+
+const (
+	hexRowBytes       = 16
+	hexWindowBytes    = 4 << 10
+	hexMaxWindowBytes = 64 << 10
+)
+
+type hexRow struct {
+	Offset int64  `json:"offset"`
+	Hex    string `json:"hex"`
+	ASCII  string `json:"ascii"`
+}
+
+type hexWindowResp struct {
+	Offset     int64    `json:"offset"`
+	PrevOffset int64    `json:"prevOffset"`
+	NextOffset int64    `json:"nextOffset"`
+	Size       int64    `json:"size"`
+	Limit      int64    `json:"limit"`
+	Rows       []hexRow `json:"rows"`
+}
+
+// hexWindow pages the open file as a hex+ASCII dump, 16 bytes a row. It
+// works in either mode and reads the bytes as stored, so binary frames and
+// UTF-16 text show up as they are. ?line= starts the window at a row.
+func hexWindow(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	defer mu.RUnlock()
+	f, err := fileFor(r)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	if !checkFile(w, r, f) {
+		return
+	}
+
+	q := r.URL.Query()
+	offset := atoi64(q.Get("offset"))
+	if line := q.Get("line"); line != "" {
+		if offset, err = f.RowOffset(atoi(line)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	limit := atoi64(q.Get("limit"))
+	if limit <= 0 {
+		limit = hexWindowBytes
+	}
+	limit = clampInt64(limit, hexRowBytes, hexMaxWindowBytes)
+	resp, err := readHexWindow(f, offset, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, resp)
+}
+
+func readHexWindow(f *indexer.File, offset, limit int64) (hexWindowResp, error) {
+	start := clampInt64(offset, 0, f.Size)
+	start -= start % hexRowBytes
+	buf := make([]byte, min(limit, f.Size-start))
+	n, err := f.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return hexWindowResp{}, err
+	}
+	buf = buf[:n]
+	rows := make([]hexRow, 0, (len(buf)+hexRowBytes-1)/hexRowBytes)
+	for i := 0; i < len(buf); i += hexRowBytes {
+		rows = append(rows, formatHexRow(start+int64(i), buf[i:min(i+hexRowBytes, len(buf))]))
+	}
+	return hexWindowResp{
+		Offset:     start,
+		PrevOffset: clampInt64(start-limit, 0, f.Size),
+		NextOffset: start + int64(n),
+		Size:       f.Size,
+		Limit:      limit,
+		Rows:       rows,
+	}, nil
+}
+
+// formatHexRow lays out b like hexdump -C: two groups of eight bytes, and
+// dots for bytes that aren't printable ASCII.
+func formatHexRow(offset int64, b []byte) hexRow {
+	const digits = "0123456789abcdef"
+	var hex, ascii strings.Builder
+	for i, c := range b {
+		if i > 0 {
+			hex.WriteByte(' ')
+			if i == hexRowBytes/2 {
+				hex.WriteByte(' ')
+			}
+		}
+		hex.WriteByte(digits[c>>4])
+		hex.WriteByte(digits[c&0x0f])
+		if c >= 0x20 && c < 0x7f {
+			ascii.WriteByte(c)
+		} else {
+			ascii.WriteByte('.')
+		}
+	}
+	return hexRow{Offset: offset, Hex: hex.String(), ASCII: ascii.String()}
+}
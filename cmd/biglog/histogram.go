@@ -0,0 +1,207 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sync"
+
+	"github.com/tm-LBenson/big-log-viewer/internal/indexer"
+)
+
+const (
+	histogramDefaultBuckets = 200
+	histogramMaxBuckets     = 10000
+	// histogramCacheMax is how many histograms are kept for repeat requests.
+	histogramCacheMax = 64
+)
+
+// histogramResp counts matches in Buckets equal slices of the file. Unit is
+// "lines" in line mode and "bytes" in byte mode, and Size is how many of
+// them the file had; bucket i covers i*Size/Buckets up to (i+1)*Size/Buckets.
+type histogramResp struct {
+	Unit    string `json:"unit"`
+	Size    int64  `json:"size"`
+	Buckets int    `json:"buckets"`
+	Counts  []int  `json:"counts"`
+	Total   int    `json:"total"`
+	Max     int    `json:"max"`
+	Cached  bool   `json:"cached"`
+}
+
+// histogramKey names one file as it was on disk and one query on it, so a
+// file that grew or changed gets a new histogram.
+type histogramKey struct {
+	path    string
+	modTime int64
+	size    int64
+	lines   int
+	mode    string
+	opts    indexer.OpenOptions
+	q       string
+	regex   bool
+	caseOn  bool
+	query   bool
+	clean   bool
+	buckets int
+}
+
+var (
+	histogramMu    sync.Mutex
+	histograms     = map[histogramKey]histogramResp{}
+	histogramOrder []histogramKey
+)
+
+func cachedHistogram(key histogramKey) (histogramResp, bool) {
+	histogramMu.Lock()
+	defer histogramMu.Unlock()
+	resp, ok := histograms[key]
+	return resp, ok
+}
+
+func cacheHistogram(key histogramKey, resp histogramResp) {
+	histogramMu.Lock()
+	defer histogramMu.Unlock()
+	if _, ok := histograms[key]; !ok {
+		histogramOrder = append(histogramOrder, key)
+	}
+	histograms[key] = resp
+	for len(histogramOrder) > histogramCacheMax {
+		delete(histograms, histogramOrder[0])
+		histogramOrder = histogramOrder[1:]
+	}
+}
+
+// matchHistogram is /api/search/histogram: how many lines match q in each
+// of buckets equal slices of the file, for a minimap of where matches
+// cluster. q, regex, case and query work as for /api/search. The file is
+// scanned once, a batch at a time under the read lock, and the result is
+// kept for the next request with the same query on the unchanged file.
+func matchHistogram(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	f, err := fileFor(r)
+	if err != nil {
+		mu.RUnlock()
+		fileError(w, err)
+		return
+	}
+	if !checkFile(w, r, f) {
+		mu.RUnlock()
+		return
+	}
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		mu.RUnlock()
+		http.Error(w, "q param required", http.StatusBadRequest)
+		return
+	}
+	matcher, keep, err := requestMatcher(r, f, q)
+	if err != nil {
+		mu.RUnlock()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := histogramResp{Unit: "lines", Size: int64(f.Lines)}
+	if f.Mode == indexer.ModeByte {
+		resp = histogramResp{Unit: "bytes", Size: f.Size}
+	}
+	buckets := atoi(r.URL.Query().Get("buckets"))
+	if buckets <= 0 {
+		buckets = histogramDefaultBuckets
+	}
+	resp.Buckets = int(min(int64(min(buckets, histogramMaxBuckets)), max(resp.Size, 1)))
+	clean, _ := cleanMatching(r, f)
+	key := histogramKey{
+		path:    f.Path,
+		modTime: f.Src.ModTime().UnixNano(),
+		size:    f.Size,
+		lines:   f.Lines,
+		mode:    f.Mode,
+		opts:    f.Options,
+		q:       q,
+		regex:   r.URL.Query().Get("regex") == "1",
+		caseOn:  r.URL.Query().Get("case") == "1",
+		query:   r.URL.Query().Get("query") == "1",
+		clean:   clean,
+		buckets: resp.Buckets,
+	}
+	mu.RUnlock()
+
+	if cached, ok := cachedHistogram(key); ok {
+		cached.Cached = true
+		writeJSON(w, cached)
+		return
+	}
+
+	resp.Counts = make([]int, resp.Buckets)
+	count := func(pos int64) {
+		i := 0
+		if resp.Size > 0 {
+			i = int(min(pos*int64(resp.Buckets)/resp.Size, int64(resp.Buckets-1)))
+		}
+		resp.Counts[i]++
+	}
+
+	var step func(pos int64) (int64, error)
+	lines := splitLineJoiner{row: -1}
+	emitLine := func(row int, text string) {
+		if matcher(text) {
+			count(int64(row))
+		}
+	}
+	if resp.Unit == "bytes" {
+		countRow := func(row textWindowLine) bool {
+			if keep(row) {
+				count(row.Offset)
+			}
+			return false
+		}
+		step = func(pos int64) (int64, error) {
+			_, next, _, err := scanCleanRows(f, pos, searchStreamBytes, math.MaxInt, countRow, false)
+			if next <= pos {
+				next = min(pos+searchStreamBytes, resp.Size)
+			}
+			return next, err
+		}
+	} else {
+		step = func(pos int64) (int64, error) {
+			end := min(pos+searchStreamGroups*indexer.Group, resp.Size)
+			err := f.EachRow(int(pos), int(end), func(i int, row indexer.Row) error {
+				lines.add(i, []indexer.Row{row}, emitLine)
+				return nil
+			})
+			if err != nil {
+				return pos, err
+			}
+			return end, nil
+		}
+	}
+
+	for pos := int64(0); pos < resp.Size; {
+		if r.Context().Err() != nil {
+			return
+		}
+		mu.RLock()
+		if handleOf(f) == nil {
+			err = errSearchFileClosed
+		} else {
+			pos, err = step(pos)
+		}
+		mu.RUnlock()
+		if err == errSearchFileClosed {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	lines.flush(emitLine)
+
+	for _, n := range resp.Counts {
+		resp.Total += n
+		resp.Max = max(resp.Max, n)
+	}
+	cacheHistogram(key, resp)
+	writeJSON(w, resp)
+}
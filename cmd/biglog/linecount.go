@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/tm-LBenson/big-log-viewer/internal/indexer"
+)
+
+// countLinesInBackground controls whether byte-mode files are indexed by
+// line after they open.
+var countLinesInBackground = true
+
+// lineCountJob indexes an open byte-mode file by line and switches it to
+// line mode when done.
+type lineCountJob struct {
+	file   *indexer.File
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	state    string
+	progress indexer.Progress
+	err      error
+}
+
+type lineCountStatus struct {
+	State   string  `json:"state"`
+	Mode    string  `json:"mode"`
+	Bytes   int64   `json:"bytes"`
+	Total   int64   `json:"total"`
+	Lines   int     `json:"lines"`
+	Percent float64 `json:"percent"`
+	Error   string  `json:"error,omitempty"`
+}
+
+var (
+	lineCountMu sync.Mutex
+	lineCounts  = map[*indexer.File]*lineCountJob{}
+)
+
+// startLineCount starts counting lines of f if it is in byte mode,
+// replacing any count already running for it. Call it with mu held right
+// after f is opened in a handle.
+func startLineCount(f *indexer.File) {
+	stopLineCount(f)
+	if !countLinesInBackground || f.Mode != indexer.ModeByte {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &lineCountJob{file: f, cancel: cancel, state: "running"}
+	job.progress.Total = f.Size
+	lineCountMu.Lock()
+	lineCounts[f] = job
+	lineCountMu.Unlock()
+	go job.run(ctx, f.NewLineScan())
+}
+
+// stopLineCount cancels the count for f, if any, before f is closed.
+func stopLineCount(f *indexer.File) {
+	lineCountMu.Lock()
+	defer lineCountMu.Unlock()
+	if job := lineCounts[f]; job != nil {
+		job.cancel()
+		delete(lineCounts, f)
+	}
+}
+
+func (j *lineCountJob) run(ctx context.Context, scan *indexer.LineScan) {
+	defer j.cancel()
+	err := scan.Run(ctx, func(p indexer.Progress) {
+		j.mu.Lock()
+		j.progress = p
+		j.mu.Unlock()
+	})
+	if err == nil {
+		mu.Lock()
+		if handleOf(j.file) != nil && ctx.Err() == nil {
+			err = j.file.ApplyLineScan(scan)
+		} else {
+			err = context.Canceled
+		}
+		mu.Unlock()
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.err = err
+	switch {
+	case err == nil:
+		j.state = "done"
+	case errors.Is(err, context.Canceled):
+		j.state = "cancelled"
+	default:
+		j.state = "error"
+	}
+}
+
+// lineCountStatusHandler tells the client how far the background line
+// count for the open file has got. Once state is "done" the file is in
+// line mode and line numbers can be used everywhere.
+func lineCountStatusHandler(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	f, err := fileFor(r)
+	if err != nil {
+		mu.RUnlock()
+		fileError(w, err)
+		return
+	}
+	out := lineCountStatus{State: "idle", Mode: f.Mode, Lines: f.Lines, Total: f.Size}
+	mu.RUnlock()
+
+	lineCountMu.Lock()
+	job := lineCounts[f]
+	lineCountMu.Unlock()
+	if job != nil {
+		job.mu.Lock()
+		out.State = job.state
+		if job.state == "running" {
+			out.Bytes = job.progress.Bytes
+			out.Lines = job.progress.Lines
+			if job.progress.Total > 0 {
+				out.Percent = clampFloat(float64(out.Bytes)*100/float64(job.progress.Total), 0, 100)
+			}
+		}
+		if job.state == "done" {
+			out.Bytes, out.Percent = out.Total, 100
+		}
+		if job.err != nil {
+			out.Error = job.err.Error()
+		}
+		job.mu.Unlock()
+	}
+	writeJSON(w, out)
+}
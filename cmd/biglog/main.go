@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"crypto/sha256"
 	"embed"
 	"encoding/json"
@@ -13,6 +14,7 @@ import (
 	"io"
 	"io/fs"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
@@ -36,14 +38,15 @@ const hugeMaxWindowBytes int64 = 8 << 20
 const hugeMaxLineBytes int64 = 2 << 20
 const hugeWindowMaxRows = 2500
 const hugeSearchBytes int64 = 256 << 20
+const searchJoinMaxBytes = 64 << 20
 const idhubLogDefaultPageSize int64 = 5_000_000
 const idhubLogMaxPageSize int64 = 50_000_000
 const idhubLogDefaultMaxPages = 20_000
 
 var (
 	rootDir string
-	current *indexer.File
 
+	// mu guards the open files and their handles.
 	mu sync.RWMutex
 
 	defaultExt = []string{
@@ -60,13 +63,32 @@ var dist embed.FS
 func main() {
 	addr := flag.String("addr", "127.0.0.1:8844", "listen address")
 	flag.StringVar(&rootDir, "logdir", defaultRoot, "folder containing text logs")
+	flag.StringVar(&indexer.CacheDir, "index-cache", indexer.CacheDir, "folder for cached line indexes (empty disables)")
+	cacheMaxMB := flag.Int64("index-cache-mb", indexer.CacheMaxBytes>>20, "maximum size of the line index cache in MiB")
+	lineMaxGB := flag.Int64("line-index-max-gb", indexer.MaxIndexedBytes>>30, "largest file in GiB indexed by line (0 means no limit)")
+	flag.BoolVar(&countLinesInBackground, "count-lines", countLinesInBackground, "index byte-mode files by line in the background")
+	flag.StringVar(&recordPattern, "record-pattern", recordPattern, "regex matching the first line of a multi-line record")
+	flag.IntVar(&searchWorkers, "search-workers", searchWorkers, "goroutines one search scans with")
+	flag.IntVar(&rootSearchWorkers, "root-search-workers", rootSearchWorkers, "files one search across the log folder reads at once")
+	flag.IntVar(&maxHandles, "max-open-files", maxHandles, "most files open at once across all clients")
+	flag.DurationVar(&handleIdleTimeout, "open-file-idle", handleIdleTimeout, "close files no client has used for this long")
+	flag.IntVar(&recentFilesMax, "recent-files", recentFilesMax, "closed files kept indexed for fast reopening (0 disables)")
+	recentMB := flag.Int64("recent-files-mb", recentFilesBytes>>20, "index memory in MiB that recently closed files may keep")
 	flag.Parse()
 
+	indexer.CacheMaxBytes = *cacheMaxMB << 20
+	indexer.MaxIndexedBytes = *lineMaxGB << 30
+	recentFilesBytes = *recentMB << 20
+
 	abs, _ := filepath.Abs(rootDir)
 	rootDir = abs
 	_ = os.MkdirAll(rootDir, 0o755)
 
 	setExtensions(defaultExt, "replace")
+	if maxHandles < 1 {
+		maxHandles = 1
+	}
+	go expireHandles()
 
 	sub, err := fs.Sub(dist, "dist")
 	if err != nil {
@@ -77,15 +99,28 @@ func main() {
 	http.HandleFunc("/api/list", listDir)
 	http.HandleFunc("/api/file-info", fileInfo)
 	http.HandleFunc("/api/file-info/reveal", revealFile)
+	http.HandleFunc("/api/file-state", fileState)
 	http.HandleFunc("/api/open", openFile)
+	http.HandleFunc("/api/open/status", openStatusHandler)
+	http.HandleFunc("/api/open/events", openEvents)
+	http.HandleFunc("/api/open/cancel", openCancel)
+	http.HandleFunc("/api/close", closeFile)
+	http.HandleFunc("/api/lines/status", lineCountStatusHandler)
 	http.HandleFunc("/api/chunk", chunk)
+	http.HandleFunc("/api/records", recordsHandler)
 	http.HandleFunc("/api/window", textWindow)
 	http.HandleFunc("/api/raw-window", rawWindow)
+	http.HandleFunc("/api/hex-window", hexWindow)
 	http.HandleFunc("/api/raw", raw)
 	http.HandleFunc("/api/search", searchLines)
+	http.HandleFunc("/api/search/stream", searchStreamHandler)
+	http.HandleFunc("/api/search/root", searchRootHandler)
+	http.HandleFunc("/api/search/histogram", matchHistogram)
+	http.HandleFunc("/api/seek-time", seekTime)
 	http.HandleFunc("/api/root", getRoot)
 	http.HandleFunc("/api/root/set", setRoot)
 	http.HandleFunc("/api/range", rangeLines)
+	http.HandleFunc("/api/follow", followFile)
 	http.HandleFunc("/api/extensions", extensionsHandler)
 	http.HandleFunc("/api/update/status", updateStatusHandler)
 	http.HandleFunc("/api/update/check", updateCheckHandler)
@@ -114,8 +149,12 @@ func listDir(w http.ResponseWriter, r *http.Request) {
 		Path    string `json:"path"`
 		Size    int64  `json:"size"`
 		ModTime int64  `json:"modTime"`
+		Binary  bool   `json:"binary,omitempty"`
 	}
 	details := r.URL.Query().Get("details") == "1"
+	// With every extension allowed the sniffer hides binary files;
+	// binary=1 lists them too.
+	withBinary := r.URL.Query().Get("binary") == "1"
 	var out []string
 	var detailed []listedFile
 
@@ -128,7 +167,12 @@ func listDir(w http.ResponseWriter, r *http.Request) {
 		if err != nil || d.IsDir() {
 			return nil
 		}
-		if shouldIncludeFile(p, curExtSet, allowAllText) {
+		include := shouldIncludeFile(p, curExtSet, allowAllText)
+		binary := false
+		if !include && withBinary && allowAllText {
+			include, binary = true, true
+		}
+		if include {
 			rel, _ := filepath.Rel(rootDir, p)
 			rel = filepath.ToSlash(rel)
 			if details {
@@ -140,6 +184,7 @@ func listDir(w http.ResponseWriter, r *http.Request) {
 					Path:    rel,
 					Size:    info.Size(),
 					ModTime: info.ModTime().UnixMilli(),
+					Binary:  binary,
 				})
 			} else {
 				out = append(out, rel)
@@ -346,7 +391,7 @@ func fileInfo(w http.ResponseWriter, r *http.Request) {
 		Compressed:     compressed,
 		Format:         format,
 		Hint:           formatHint(format),
-		HugeHint:       !compressed && info.Size() > indexer.MaxIndexedBytes,
+		HugeHint:       !compressed && indexer.ExceedsLineLimit(info.Size()),
 	})
 }
 
@@ -459,42 +504,16 @@ func formatHint(format string) string {
 	}
 }
 
-func openFile(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Query().Get("path")
-	if path == "" {
-		http.Error(w, "path param required", 400)
-		return
-	}
-	abs, err := resolveLogPath(path)
-	if err != nil {
-		http.Error(w, err.Error(), 403)
-		return
-	}
-	f, err := indexer.Open(abs)
+func chunk(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	f, err := fileFor(r)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		mu.RUnlock()
+		fileError(w, err)
 		return
 	}
-	mu.Lock()
-	if current != nil {
-		_ = current.Close()
-	}
-	current = f
-	mu.Unlock()
-	writeJSON(w, struct {
-		Lines     int    `json:"Lines"`
-		Size      int64  `json:"Size"`
-		Mode      string `json:"Mode"`
-		ChunkSize int64  `json:"ChunkSize,omitempty"`
-	}{f.Lines, f.Size, f.Mode, f.ChunkSize})
-}
-
-func chunk(w http.ResponseWriter, r *http.Request) {
-	mu.RLock()
-	f := current
-	if f == nil {
+	if !checkFile(w, r, f) {
 		mu.RUnlock()
-		http.Error(w, "no file", 400)
 		return
 	}
 	start := atoi(r.URL.Query().Get("start"))
@@ -502,11 +521,36 @@ func chunk(w http.ResponseWriter, r *http.Request) {
 	if count <= 0 {
 		count = 400
 	}
+	details := r.URL.Query().Get("details") == "1"
+	if r.URL.Query().Get("records") == "1" {
+		resp, err := recordChunk(f, start, count)
+		mu.RUnlock()
+		if err != nil {
+			recordsError(w, err)
+			return
+		}
+		writeJSON(w, resp)
+		return
+	}
 	if f.Lines == 0 {
 		mu.RUnlock()
+		if details {
+			writeJSON(w, chunkResp{Lines: []string{}})
+			return
+		}
 		writeJSON(w, []string{})
 		return
 	}
+	if details {
+		rows, err := f.SliceRows(start, count)
+		mu.RUnlock()
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		writeJSON(w, splitRows(rows, start))
+		return
+	}
 	lines, err := f.LinesSlice(start, count)
 	mu.RUnlock()
 	if err != nil {
@@ -516,10 +560,39 @@ func chunk(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, lines)
 }
 
+// chunkResp is the details=1 form of /api/chunk. Continued lists the row
+// numbers that are later segments of a split over-long line, and Binary the
+// ones holding non-printable bytes. With records=1 Lines holds whole records
+// and Rows the row each one starts at.
+type chunkResp struct {
+	Lines     []string `json:"lines"`
+	Continued []int    `json:"continued,omitempty"`
+	Rows      []int    `json:"rows,omitempty"`
+	Binary    []int    `json:"binary,omitempty"`
+	Truncated []int    `json:"truncated,omitempty"`
+}
+
+func splitRows(rows []indexer.Row, start int) chunkResp {
+	resp := chunkResp{Lines: make([]string, len(rows))}
+	for i, row := range rows {
+		resp.Lines[i] = row.Text
+		if row.Continued {
+			resp.Continued = append(resp.Continued, start+i)
+		}
+		if indexer.HasNonPrintable(row.Text) {
+			resp.Binary = append(resp.Binary, start+i)
+		}
+	}
+	return resp
+}
+
+// textWindowLine is one cleaned row of a byte-mode window. Binary marks
+// rows whose bytes include non-printable characters.
 type textWindowLine struct {
 	Offset int64  `json:"offset"`
 	Text   string `json:"text"`
 	Tone   string `json:"tone,omitempty"`
+	Binary bool   `json:"binary,omitempty"`
 }
 
 type textWindowResp struct {
@@ -545,22 +618,21 @@ type hugeSearchResp struct {
 	ScannedBytes int64            `json:"ScannedBytes"`
 	NextOffset   int64            `json:"NextOffset"`
 	More         bool             `json:"More"`
+	Context      []searchContext  `json:"Context,omitempty"`
 }
 
 func textWindow(w http.ResponseWriter, r *http.Request) {
 	mu.RLock()
-	f := current
-	if f == nil {
+	f, err := fileFor(r)
+	if err != nil {
 		mu.RUnlock()
-		http.Error(w, "no file", http.StatusBadRequest)
+		fileError(w, err)
 		return
 	}
-	if f.Mode != indexer.ModeByte {
+	if !checkFile(w, r, f) {
 		mu.RUnlock()
-		http.Error(w, "window mode is only available for huge files", http.StatusBadRequest)
 		return
 	}
-
 	offset := clampInt64(atoi64(r.URL.Query().Get("offset")), 0, f.Size)
 	limit := atoi64(r.URL.Query().Get("limit"))
 	if limit <= 0 {
@@ -582,15 +654,14 @@ func textWindow(w http.ResponseWriter, r *http.Request) {
 
 func rawWindow(w http.ResponseWriter, r *http.Request) {
 	mu.RLock()
-	f := current
-	if f == nil {
+	f, err := fileFor(r)
+	if err != nil {
 		mu.RUnlock()
-		http.Error(w, "no file", http.StatusBadRequest)
+		fileError(w, err)
 		return
 	}
-	if f.Mode != indexer.ModeByte {
+	if !checkFile(w, r, f) {
 		mu.RUnlock()
-		http.Error(w, "raw window mode is only available for huge files", http.StatusBadRequest)
 		return
 	}
 	defer mu.RUnlock()
@@ -603,13 +674,13 @@ func rawWindow(w http.ResponseWriter, r *http.Request) {
 	if limit > hugeMaxWindowBytes {
 		limit = hugeMaxWindowBytes
 	}
+	offset = f.AlignOffset(offset)
 	if remaining := f.Size - offset; limit > remaining {
 		limit = remaining
 	}
-	reader := io.NewSectionReader(f.File, offset, limit)
 
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	if _, err := io.Copy(w, reader); err != nil {
+	if err := f.WriteText(w, offset, offset+limit); err != nil {
 		log.Printf("raw window copy failed: %v", err)
 	}
 }
@@ -629,25 +700,54 @@ func raw(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "path outside root", 403)
 		return
 	}
-	if indexer.IsGzipPath(abs) && r.Method != http.MethodHead {
-		tempPath, cleanup, err := indexer.DecompressGzipToTemp(abs)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		defer cleanup()
-		http.ServeFile(w, r, tempPath)
+	if indexer.IsGzipPath(abs) {
+		serveGzipRaw(w, r, abs)
 		return
 	}
 	http.ServeFile(w, r, abs)
 }
 
+// serveGzipRaw streams the decompressed text of a .gz, typed by the name
+// it has without the .gz. It can't seek, so ranges aren't supported.
+func serveGzipRaw(w http.ResponseWriter, r *http.Request, abs string) {
+	file, err := os.Open(abs)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, fs.ErrNotExist) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer gz.Close()
+
+	ctype := mime.TypeByExtension(filepath.Ext(strings.TrimSuffix(abs, filepath.Ext(abs))))
+	if ctype == "" {
+		ctype = "text/plain; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", ctype)
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = io.Copy(w, gz)
+}
+
 func searchLines(w http.ResponseWriter, r *http.Request) {
 	mu.RLock()
-	f := current
-	if f == nil {
+	f, err := fileFor(r)
+	if err != nil {
+		mu.RUnlock()
+		fileError(w, err)
+		return
+	}
+	if !checkFile(w, r, f) {
 		mu.RUnlock()
-		http.Error(w, "open a file first", 400)
 		return
 	}
 	q := r.URL.Query().Get("q")
@@ -660,18 +760,34 @@ func searchLines(w http.ResponseWriter, r *http.Request) {
 	if limit <= 0 {
 		limit = 500
 	}
-	matcher, err := newTextMatcher(
-		q,
-		r.URL.Query().Get("regex") == "1",
-		r.URL.Query().Get("case") == "1",
-	)
+	matcher, keep, err := requestMatcher(r, f, q)
 	if err != nil {
 		mu.RUnlock()
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if f.Mode == indexer.ModeByte {
-		resp, err := searchHugeFile(r, f, matcher, limit)
+	// before and after add the lines around each match as Context.
+	before, after, rawContext := contextParams(r, f)
+	withContext := before > 0 || after > 0
+	if r.URL.Query().Get("records") == "1" {
+		matches, total, err := searchRecords(f, matcher, limit)
+		var context []searchContext
+		if err == nil && withContext {
+			context, err = recordContext(f, matches, before, after, rawContext)
+		}
+		mu.RUnlock()
+		if err != nil {
+			recordsError(w, err)
+			return
+		}
+		writeJSON(w, lineSearchResp{matches, total, context})
+		return
+	}
+	if byteSearch(r, f) {
+		resp, err := searchHugeFile(r, f, keep, limit)
+		if err == nil && withContext {
+			resp.Context, err = byteContext(f, resp.Items, before, after, rawContext)
+		}
 		mu.RUnlock()
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -680,28 +796,62 @@ func searchLines(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, resp)
 		return
 	}
-	matches := make([]int, 0, limit)
-	total := 0
-	for g := 0; g*indexer.Group < f.Lines; g++ {
-		start := g * indexer.Group
-		lines, err := f.LinesSlice(start, indexer.Group)
-		if err != nil {
-			break
+	matches, total, err := searchLineRanges(f, matcher, limit)
+	var context []searchContext
+	if err == nil && withContext {
+		context, err = rowContext(f, matches, before, after, rawContext)
+	}
+	mu.RUnlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, lineSearchResp{matches, total, context})
+}
+
+// byteSearch reports whether /api/search should answer by byte offset, as
+// it does for byte-mode files. The huge-file viewer always sends offset, and
+// keeps getting byte results after a background count moves the file it
+// shows to line mode.
+func byteSearch(r *http.Request, f *indexer.File) bool {
+	return f.Mode == indexer.ModeByte || r.URL.Query().Get("offset") != ""
+}
+
+// lineSearchResp is /api/search in line mode and with records=1.
+type lineSearchResp struct {
+	Matches []int           `json:"Matches"`
+	Total   int             `json:"Total"`
+	Context []searchContext `json:"Context,omitempty"`
+}
+
+// splitLineJoiner puts the segments of split lines back together, so they
+// are matched as the whole line and reported at its first row, unless the
+// line is too big to join.
+type splitLineJoiner struct {
+	row  int
+	line strings.Builder
+}
+
+// add feeds rows starting at row start, calling emit for each line they
+// complete.
+func (j *splitLineJoiner) add(start int, rows []indexer.Row, emit func(row int, text string)) {
+	for i, row := range rows {
+		if !row.Continued || j.line.Len() >= searchJoinMaxBytes {
+			j.flush(emit)
 		}
-		for i, ln := range lines {
-			if matcher(ln) {
-				total++
-				if len(matches) < limit {
-					matches = append(matches, start+i)
-				}
-			}
+		if j.row < 0 {
+			j.row = start + i
 		}
+		j.line.WriteString(row.Text)
 	}
-	mu.RUnlock()
-	writeJSON(w, struct {
-		Matches []int `json:"Matches"`
-		Total   int   `json:"Total"`
-	}{matches, total})
+}
+
+func (j *splitLineJoiner) flush(emit func(row int, text string)) {
+	if j.row >= 0 {
+		emit(j.row, j.line.String())
+	}
+	j.line.Reset()
+	j.row = -1
 }
 
 func newTextMatcher(q string, regexMode bool, caseSensitive bool) (func(string) bool, error) {
@@ -743,6 +893,7 @@ func readTextWindow(f *indexer.File, offset, limit int64, align bool, tail bool)
 	if tail {
 		start = clampInt64(start-limit, 0, f.Size)
 	}
+	start = f.AlignOffset(start)
 	if align {
 		start = lineStartAtOrBefore(f, start)
 	}
@@ -764,7 +915,7 @@ func readTextWindow(f *indexer.File, offset, limit int64, align bool, tail bool)
 	}, nil
 }
 
-func searchHugeFile(r *http.Request, f *indexer.File, matcher func(string) bool, limit int) (hugeSearchResp, error) {
+func searchHugeFile(r *http.Request, f *indexer.File, keep func(textWindowLine) bool, limit int) (hugeSearchResp, error) {
 	if limit <= 0 || limit > 500 {
 		limit = 100
 	}
@@ -777,9 +928,7 @@ func searchHugeFile(r *http.Request, f *indexer.File, matcher func(string) bool,
 		maxBytes = 2 << 30
 	}
 	items := make([]hugeSearchItem, 0, limit)
-	lines, next, truncated, err := scanCleanRows(f, offset, maxBytes, limit, func(_ []byte, text string) bool {
-		return matcher(text)
-	}, false)
+	lines, next, truncated, err := scanByteRanges(f, offset, maxBytes, limit, keep)
 	if err != nil {
 		return hugeSearchResp{}, err
 	}
@@ -802,7 +951,7 @@ func searchHugeFile(r *http.Request, f *indexer.File, matcher func(string) bool,
 	}, nil
 }
 
-func scanCleanRows(f *indexer.File, offset, limit int64, maxRows int, keep func([]byte, string) bool, tail bool) ([]textWindowLine, int64, bool, error) {
+func scanCleanRows(f *indexer.File, offset, limit int64, maxRows int, keep func(textWindowLine) bool, tail bool) ([]textWindowLine, int64, bool, error) {
 	if offset >= f.Size {
 		return []textWindowLine{}, f.Size, false, nil
 	}
@@ -814,8 +963,8 @@ func scanCleanRows(f *indexer.File, offset, limit int64, maxRows int, keep func(
 		readLimit = remaining
 	}
 
-	sr := io.NewSectionReader(f.File, offset, readLimit)
-	r := bufio.NewReaderSize(sr, 1<<20)
+	sr := io.NewSectionReader(f, offset, readLimit)
+	r := f.NewLineReader(sr, 1<<20)
 	rows := make([]textWindowLine, 0, 512)
 	current := offset
 	lineOffset := offset
@@ -826,8 +975,8 @@ func scanCleanRows(f *indexer.File, offset, limit int64, maxRows int, keep func(
 			raw = raw[:0]
 			return
 		}
-		for _, row := range cleanLogRows(raw, lineOffset) {
-			if keep != nil && !keep(raw, row.Text) {
+		for _, row := range cleanLogRows(f, raw, lineOffset) {
+			if keep != nil && !keep(row) {
 				continue
 			}
 			rows = append(rows, row)
@@ -843,7 +992,7 @@ func scanCleanRows(f *indexer.File, offset, limit int64, maxRows int, keep func(
 	}
 
 	for current-offset < readLimit && (tail || len(rows) < maxRows) {
-		part, err := r.ReadSlice('\n')
+		part, err := r.ReadSlice()
 		if len(part) > 0 {
 			raw = append(raw, part...)
 			current += int64(len(part))
@@ -874,11 +1023,23 @@ func scanCleanRows(f *indexer.File, offset, limit int64, maxRows int, keep func(
 	return rows, current, current < f.Size, nil
 }
 
-func cleanLogRows(raw []byte, baseOffset int64) []textWindowLine {
-	s := string(raw)
+func cleanLogRows(f *indexer.File, raw []byte, baseOffset int64) []textWindowLine {
+	s := indexer.DecodeText(f.Encoding, raw)
+	// Offsets inside transcoded text no longer match the file, so every
+	// segment of such a line points at the line's own start.
+	return cleanTextRows(s, baseOffset, len(s) == len(raw))
+}
+
+// cleanTextRows cleans decoded text into rows, with offsets counted from
+// baseOffset, or all at baseOffset unless exact.
+func cleanTextRows(s string, baseOffset int64, exact bool) []textWindowLine {
 	rows := make([]textWindowLine, 0, 16)
 	appendSegment := func(segment string, offset int64) {
+		if !exact {
+			offset = baseOffset
+		}
 		cleaned := cleanLogText(segment)
+		binary := indexer.HasNonPrintable(segment)
 		for _, part := range strings.Split(cleaned, "\n") {
 			part = strings.TrimRight(part, " \t\r")
 			if strings.TrimSpace(part) == "" {
@@ -888,6 +1049,7 @@ func cleanLogRows(raw []byte, baseOffset int64) []textWindowLine {
 				Offset: offset,
 				Text:   part,
 				Tone:   detectLogTone(segment, part),
+				Binary: binary,
 			})
 		}
 	}
@@ -975,6 +1137,15 @@ func lineStartAtOrBefore(f *indexer.File, offset int64) int64 {
 	if offset > f.Size {
 		offset = f.Size
 	}
+	offset = f.AlignOffset(offset)
+	if f.Mode == indexer.ModeLine {
+		// The line index knows where each row starts.
+		if row, err := f.RowAt(offset); err == nil && row < f.Lines {
+			if start, err := f.RowOffset(row); err == nil && start <= offset && offset-start <= hugeMaxLineBytes {
+				return start
+			}
+		}
+	}
 	pos := offset
 	searched := int64(0)
 	buf := make([]byte, 64<<10)
@@ -988,14 +1159,12 @@ func lineStartAtOrBefore(f *indexer.File, offset int64) int64 {
 		}
 		start := pos - n
 		readBuf := buf[:int(n)]
-		_, err := f.File.ReadAt(readBuf, start)
+		_, err := f.ReadAt(readBuf, start)
 		if err != nil && err != io.EOF {
 			return offset
 		}
-		for i := len(readBuf) - 1; i >= 0; i-- {
-			if readBuf[i] == '\n' {
-				return start + int64(i) + 1
-			}
+		if end := f.LastLineEnd(readBuf); end >= 0 {
+			return start + int64(end)
 		}
 		pos = start
 		searched += n
@@ -1024,24 +1193,37 @@ func setRoot(w http.ResponseWriter, r *http.Request) {
 	}
 	abs, _ := filepath.Abs(req.Path)
 	mu.Lock()
-	if current != nil {
-		_ = current.Close()
-		current = nil
-	}
+	closeAllHandles()
 	rootDir = abs
 	mu.Unlock()
+	closeRecentFiles()
 	writeJSON(w, struct{ Path string }{rootDir})
 }
 
 func rangeLines(w http.ResponseWriter, r *http.Request) {
 	mu.RLock()
-	f := current
-	if f == nil {
+	f, err := fileFor(r)
+	if err != nil {
+		mu.RUnlock()
+		fileError(w, err)
+		return
+	}
+	if !checkFile(w, r, f) {
 		mu.RUnlock()
-		http.Error(w, "no file", 400)
 		return
 	}
 
+	records := r.URL.Query().Get("records") == "1"
+	total, unit := f.Lines, "lines"
+	if records {
+		if f.Records == nil {
+			mu.RUnlock()
+			recordsError(w, indexer.ErrNoRecords)
+			return
+		}
+		total, unit = f.Records.Len(), "records"
+	}
+
 	start := atoi(r.URL.Query().Get("start"))
 	end := atoi(r.URL.Query().Get("end"))
 	count := atoi(r.URL.Query().Get("count"))
@@ -1051,8 +1233,8 @@ func rangeLines(w http.ResponseWriter, r *http.Request) {
 	if start < 0 {
 		start = 0
 	}
-	if end <= 0 || end > f.Lines {
-		end = f.Lines
+	if end <= 0 || end > total {
+		end = total
 	}
 	if end < start {
 		end = start
@@ -1060,14 +1242,18 @@ func rangeLines(w http.ResponseWriter, r *http.Request) {
 
 	name := r.URL.Query().Get("name")
 	if name == "" {
-		name = fmt.Sprintf("lines_%d-%d.txt", start+1, end)
+		name = fmt.Sprintf("%s_%d-%d.txt", unit, start+1, end)
 	}
 	if r.URL.Query().Get("download") == "1" {
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
 	}
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 
-	err := f.WriteRange(w, start, end)
+	if records {
+		err = f.WriteRecords(w, start, end)
+	} else {
+		err = f.WriteRange(w, start, end)
+	}
 	mu.RUnlock()
 	if err != nil {
 		http.Error(w, err.Error(), 500)
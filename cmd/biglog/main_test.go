@@ -1,13 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+	"unicode/utf16"
 
 	"github.com/tm-LBenson/big-log-viewer/internal/indexer"
 )
@@ -19,19 +27,10 @@ func TestReadTextWindowCleansHugeHTMLLog(t *testing.T) {
 		`<font color="red">2026/06/23 10:00:01 ERROR Needle failed</font>`,
 		"</pre></body></html>",
 	}, "\n")
-	path := filepath.Join(t.TempDir(), "huge.html")
-	if err := os.WriteFile(path, []byte(raw), 0o600); err != nil {
-		t.Fatal(err)
-	}
-	handle, err := os.Open(path)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer handle.Close()
-
+	src := indexer.NewMemorySource("huge.html", []byte(raw))
 	f := &indexer.File{
-		Path: path,
-		File: handle,
+		Path: src.ID(),
+		Src:  src,
 		Size: int64(len(raw)),
 		Mode: indexer.ModeByte,
 	}
@@ -75,25 +74,16 @@ func TestHugeSearchRowsKeepNearMatchOffsets(t *testing.T) {
 	entry := `<font color="blue">2026/06/23 10:00:00 INFO Processing account output &amp; checkpoint</font>`
 	needle := `<font color="red">2026/06/23 10:00:01 ERROR Needle failed here &amp; decoded</font>`
 	raw := "<html><body><pre>" + strings.Repeat(entry, 20000) + needle + "</pre></body></html>"
-	path := filepath.Join(t.TempDir(), "huge.html")
-	if err := os.WriteFile(path, []byte(raw), 0o600); err != nil {
-		t.Fatal(err)
-	}
-	handle, err := os.Open(path)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer handle.Close()
-
+	src := indexer.NewMemorySource("huge.html", []byte(raw))
 	f := &indexer.File{
-		Path: path,
-		File: handle,
+		Path: src.ID(),
+		Src:  src,
 		Size: int64(len(raw)),
 		Mode: indexer.ModeByte,
 	}
 
-	rows, _, _, err := scanCleanRows(f, 0, f.Size, 1, func(_ []byte, text string) bool {
-		return strings.Contains(text, "Needle failed here")
+	rows, _, _, err := scanCleanRows(f, 0, f.Size, 1, func(row textWindowLine) bool {
+		return strings.Contains(row.Text, "Needle failed here")
 	}, false)
 	if err != nil {
 		t.Fatal(err)
@@ -114,19 +104,10 @@ func TestReadTextWindowTailKeepsEndRows(t *testing.T) {
 	entry := `<font color="blue">2026/06/23 10:00:00 INFO Processing account output &amp; checkpoint</font>`
 	tail := `<font color="green">2026/06/23 10:00:02 INFO Tail marker at end &amp; decoded</font>`
 	raw := "<html><body><pre>" + strings.Repeat(entry, hugeWindowMaxRows+500) + tail + "</pre></body></html>"
-	path := filepath.Join(t.TempDir(), "tail.html")
-	if err := os.WriteFile(path, []byte(raw), 0o600); err != nil {
-		t.Fatal(err)
-	}
-	handle, err := os.Open(path)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer handle.Close()
-
+	src := indexer.NewMemorySource("tail.html", []byte(raw))
 	f := &indexer.File{
-		Path: path,
-		File: handle,
+		Path: src.ID(),
+		Src:  src,
 		Size: int64(len(raw)),
 		Mode: indexer.ModeByte,
 	}
@@ -294,6 +275,35 @@ func TestFileInfoReportsCompressedFormat(t *testing.T) {
 	}
 }
 
+func TestRawStreamsDecompressedGzip(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte("<b>one</b>\ntwo\n"))
+	gz.Close()
+	if err := os.WriteFile(filepath.Join(dir, "sample.html.gz"), buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	oldRoot := rootDir
+	rootDir = dir
+	t.Cleanup(func() { rootDir = oldRoot })
+
+	rr := httptest.NewRecorder()
+	raw(rr, httptest.NewRequest("GET", "/api/raw?path=sample.html.gz", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "<b>one</b>\ntwo\n" {
+		t.Fatalf("status = %d, body = %q", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("content type = %q", ct)
+	}
+
+	rr = httptest.NewRecorder()
+	raw(rr, httptest.NewRequest("HEAD", "/api/raw?path=sample.html.gz", nil))
+	if rr.Code != http.StatusOK || rr.Body.Len() != 0 || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("HEAD status = %d, headers = %v", rr.Code, rr.Header())
+	}
+}
+
 func TestFileInfoReportsJSONLHint(t *testing.T) {
 	dir := t.TempDir()
 	if err := os.WriteFile(filepath.Join(dir, "records.jsonl"), []byte(`{"ok":true}`+"\n"), 0o600); err != nil {
@@ -426,3 +436,1251 @@ func TestDetectLogToneFromText(t *testing.T) {
 		}
 	}
 }
+
+func TestFollowStepReportsAppendedRowsAndRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "live.log")
+	if err := os.WriteFile(path, []byte("first\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := indexer.Open(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useFile(t, f)
+
+	if _, _, changed, err := followStep(context.Background(), f, indexer.ModeLine, true); err != nil || changed {
+		t.Fatalf("unchanged file: changed=%v err=%v", changed, err)
+	}
+
+	handle, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = handle.WriteString("second\nthird\n")
+	handle.Close()
+
+	ev, next, changed, err := followStep(context.Background(), f, indexer.ModeLine, true)
+	if err != nil || !changed {
+		t.Fatalf("append: changed=%v err=%v", changed, err)
+	}
+	if ev.Type != "append" || ev.From != 1 || ev.Lines != 3 || strings.Join(ev.Rows, "") != "second\nthird\n" {
+		t.Fatalf("append event = %+v", ev)
+	}
+
+	rotated := filepath.Join(dir, "rotated.log")
+	if err := os.WriteFile(rotated, []byte("fresh\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(rotated, path); err != nil {
+		t.Fatal(err)
+	}
+	ev, next, changed, err = followStep(context.Background(), next, indexer.ModeLine, true)
+	if err != nil || !changed {
+		t.Fatalf("rotate: changed=%v err=%v", changed, err)
+	}
+	if ev.Type != "reset" || ev.Lines != 1 || next == f {
+		t.Fatalf("rotate event = %+v", ev)
+	}
+}
+
+func TestOpenAsyncInstallsFileAndCancelSupersedes(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.log"), []byte("one\ntwo\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	oldRoot := rootDir
+	rootDir = dir
+	isolateHandles(t)
+	t.Cleanup(func() { rootDir = oldRoot })
+
+	rr := httptest.NewRecorder()
+	openFile(rr, httptest.NewRequest("GET", "/api/open?path=a.log&async=1", nil))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202; body=%s", rr.Code, rr.Body.String())
+	}
+	var started openStatus
+	if err := json.NewDecoder(rr.Body).Decode(&started); err != nil {
+		t.Fatal(err)
+	}
+	job, ok := getOpenJob(started.ID)
+	if !ok {
+		t.Fatalf("job %q not registered", started.ID)
+	}
+	<-job.done
+
+	rr = httptest.NewRecorder()
+	openStatusHandler(rr, httptest.NewRequest("GET", "/api/open/status?id="+started.ID, nil))
+	var st openStatus
+	if err := json.NewDecoder(rr.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	if st.State != "done" || st.Result == nil || st.Result.Lines != 2 || st.Percent != 100 {
+		t.Fatalf("status = %+v", st)
+	}
+	mu.RLock()
+	installed := defaultHandle != nil && defaultHandle.ID == st.Result.Handle && defaultHandle.file.Lines == 2
+	mu.RUnlock()
+	if !installed {
+		t.Fatal("opened file was not installed in the default handle")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cancelled := startOpen(ctx, filepath.Join(dir, "a.log"), indexer.OpenOptions{}, "")
+	<-cancelled.done
+	if st := cancelled.status(); st.State != "cancelled" {
+		t.Fatalf("cancelled open state = %q (%s)", st.State, st.Error)
+	}
+}
+
+func TestSearchMatchesAcrossSplitLineSegments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blob.log")
+	cut := int(indexer.MaxIndexedLineBytes)
+	body := "head\n" + strings.Repeat("a", cut-4) + "NEEDLE" + strings.Repeat("b", 10) + "\ntail NEEDLE\n"
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := indexer.Open(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useFile(t, f)
+
+	rr := httptest.NewRecorder()
+	searchLines(rr, httptest.NewRequest("GET", "/api/search?q=needle", nil))
+	var resp struct {
+		Matches []int `json:"Matches"`
+		Total   int   `json:"Total"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Total != 2 || len(resp.Matches) != 2 || resp.Matches[0] != 1 || resp.Matches[1] != 3 {
+		t.Fatalf("search = %+v, want matches at rows 1 and 3", resp)
+	}
+
+	rr = httptest.NewRecorder()
+	chunk(rr, httptest.NewRequest("GET", "/api/chunk?start=0&count=10&details=1", nil))
+	var rows chunkResp
+	if err := json.NewDecoder(rr.Body).Decode(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows.Lines) != 4 || len(rows.Continued) != 1 || rows.Continued[0] != 2 {
+		t.Fatalf("chunk details = %d rows, continued %v; want 4 rows with row 2 continued", len(rows.Lines), rows.Continued)
+	}
+}
+
+func TestTextWindowTranscodesUTF16AndEncodingOverrideSticks(t *testing.T) {
+	dir := t.TempDir()
+	text := "\ufeffINFO started\r\nERROR Needle failed\r\n"
+	units := utf16.Encode([]rune(text))
+	body := make([]byte, 0, 2*len(units))
+	for _, u := range units {
+		body = append(body, byte(u), byte(u>>8))
+	}
+	path := filepath.Join(dir, "win.log")
+	if err := os.WriteFile(path, body, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	oldMax := indexer.MaxIndexedBytes
+	indexer.MaxIndexedBytes = 1
+	t.Cleanup(func() { indexer.MaxIndexedBytes = oldMax })
+	f, err := indexer.Open(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if f.Mode != indexer.ModeByte || f.Encoding != indexer.EncodingUTF16LE {
+		t.Fatalf("open = %s/%s, want byte mode UTF-16LE", f.Mode, f.Encoding)
+	}
+
+	window, err := readTextWindow(f, 7, 1024, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(window.Lines) != 2 || window.Lines[0].Text != "INFO started" || window.Lines[1].Text != "ERROR Needle failed" {
+		t.Fatalf("window lines = %#v", window.Lines)
+	}
+	if window.Lines[1].Offset != 30 {
+		t.Fatalf("second line offset = %d", window.Lines[1].Offset)
+	}
+
+	abs, _ := filepath.Abs(path)
+	t.Cleanup(func() {
+		_, _ = openOptionsFor(abs, url.Values{"encoding": {"auto"}, "lineEnding": {"auto"}})
+	})
+	opts, err := openOptionsFor(abs, url.Values{"encoding": {"UTF16-BE"}})
+	if err != nil || opts.Encoding != indexer.EncodingUTF16BE {
+		t.Fatalf("override = %+v, %v", opts, err)
+	}
+	if opts, _ := openOptionsFor(abs, url.Values{"lineEnding": {"cr"}}); opts.Encoding != indexer.EncodingUTF16BE || opts.LineEnding != indexer.LineEndingCR {
+		t.Fatalf("remembered override = %+v", opts)
+	}
+	if _, err := openOptionsFor(abs, url.Values{"encoding": {"ebcdic"}}); err == nil {
+		t.Fatal("expected unsupported encoding error")
+	}
+}
+
+func TestRecordModeChunksSearchesAndExportsWholeRecords(t *testing.T) {
+	body := "2026-01-02 10:00:00 INFO start\n" +
+		"2026-01-02 10:00:01 ERROR boom\n" +
+		"java.lang.IllegalStateException: bad\n" +
+		"\tat com.example.Main(Main.java:10)\n" +
+		"2026-01-02 10:00:02 INFO done\n"
+	src := indexer.NewMemorySource("records.log", []byte(body))
+	f, err := indexer.OpenSource(context.Background(), src, indexer.OpenOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useFile(t, f)
+
+	rr := httptest.NewRecorder()
+	recordsHandler(rr, httptest.NewRequest("POST", "/api/records", strings.NewReader(`{"enabled":true}`)))
+	var status recordsResp
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if !status.Enabled || status.Records != 3 || status.Lines != 5 {
+		t.Fatalf("records status = %+v", status)
+	}
+
+	rr = httptest.NewRecorder()
+	chunk(rr, httptest.NewRequest("GET", "/api/chunk?start=1&count=5&records=1", nil))
+	var recs chunkResp
+	if err := json.NewDecoder(rr.Body).Decode(&recs); err != nil {
+		t.Fatal(err)
+	}
+	if len(recs.Lines) != 2 || len(recs.Rows) != 2 || recs.Rows[0] != 1 || recs.Rows[1] != 4 ||
+		!strings.HasSuffix(recs.Lines[0], "(Main.java:10)\n") {
+		t.Fatalf("record chunk = %+v", recs)
+	}
+
+	rr = httptest.NewRecorder()
+	searchLines(rr, httptest.NewRequest("GET", "/api/search?q=%28%3Fs%29boom.%2AIllegalState&regex=1&records=1", nil))
+	var found struct {
+		Matches []int `json:"Matches"`
+		Total   int   `json:"Total"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&found); err != nil {
+		t.Fatal(err)
+	}
+	if found.Total != 1 || found.Matches[0] != 1 {
+		t.Fatalf("record search = %+v, want record 1", found)
+	}
+
+	rr = httptest.NewRecorder()
+	rangeLines(rr, httptest.NewRequest("GET", "/api/range?start=1&end=2&records=1", nil))
+	if want := strings.Join(strings.SplitAfter(body, "\n")[1:4], ""); rr.Body.String() != want {
+		t.Fatalf("record export = %q, want %q", rr.Body.String(), want)
+	}
+}
+
+func TestSeekTimeReportsRowAndRecord(t *testing.T) {
+	body := "2025/10/06 15:29:20.746 INFO start\n" +
+		"2025/10/06 15:39:59.000 ERROR sync failed\n" +
+		"  at Sync.run\n" +
+		"2025/10/06 15:40:03.120 INFO retry\n"
+	f, err := indexer.OpenSource(context.Background(), indexer.NewMemorySource("seek.log", []byte(body)), indexer.OpenOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs, err := f.ScanRecords(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = f.SetRecords(recs)
+	useFile(t, f)
+
+	rr := httptest.NewRecorder()
+	seekTime(rr, httptest.NewRequest("GET", "/api/seek-time?time=2025-10-06+15:40", nil))
+	var resp seekTimeResp
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Found || resp.Line != 3 || resp.Offset != int64(strings.Index(body, "2025/10/06 15:40")) ||
+		resp.Record == nil || *resp.Record != 2 {
+		t.Fatalf("seek-time = %+v", resp)
+	}
+
+	rr = httptest.NewRecorder()
+	seekTime(rr, httptest.NewRequest("GET", "/api/seek-time?time=yesterday", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("bad time status = %d", rr.Code)
+	}
+}
+
+func TestBackgroundLineCountSwitchesToLineMode(t *testing.T) {
+	old := indexer.MaxIndexedBytes
+	indexer.MaxIndexedBytes = 16
+	body := strings.Repeat("counted row\n", 1000)
+	f, err := indexer.OpenSource(context.Background(), indexer.NewMemorySource("huge.log", []byte(body)), indexer.OpenOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useFile(t, f)
+	t.Cleanup(func() {
+		indexer.MaxIndexedBytes = old
+	})
+
+	var st lineCountStatus
+	deadline := time.Now().Add(5 * time.Second)
+	for st.State != "done" {
+		if time.Now().After(deadline) {
+			t.Fatalf("line count did not finish: %+v", st)
+		}
+		rr := httptest.NewRecorder()
+		lineCountStatusHandler(rr, httptest.NewRequest("GET", "/api/lines/status", nil))
+		st = lineCountStatus{}
+		if err := json.NewDecoder(rr.Body).Decode(&st); err != nil {
+			t.Fatal(err)
+		}
+		if st.State == "error" {
+			t.Fatalf("line count failed: %s", st.Error)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st.Mode != indexer.ModeLine || st.Lines != 1000 || st.Percent != 100 {
+		t.Fatalf("status = %+v", st)
+	}
+
+	rr := httptest.NewRecorder()
+	rangeLines(rr, httptest.NewRequest("GET", "/api/range?start=998&end=1000", nil))
+	if rr.Body.String() != "counted row\ncounted row\n" {
+		t.Fatalf("range after upgrade = %q", rr.Body.String())
+	}
+}
+
+func TestByteModeViewerWorksAfterLineCount(t *testing.T) {
+	old := indexer.MaxIndexedBytes
+	indexer.MaxIndexedBytes = 16
+	t.Cleanup(func() { indexer.MaxIndexedBytes = old })
+	var b strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&b, "row %04d\n", i)
+	}
+	f, err := indexer.OpenSource(context.Background(), indexer.NewMemorySource("huge.log", []byte(b.String())), indexer.OpenOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useFile(t, f)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.RLock()
+		mode := f.Mode
+		mu.RUnlock()
+		if mode == indexer.ModeLine {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("line count did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Offset 4505 is inside "row 0500\n", which starts at 4500.
+	rr := httptest.NewRecorder()
+	textWindow(rr, httptest.NewRequest("GET", "/api/window?offset=4505&limit=18", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("window status = %d: %s", rr.Code, rr.Body.String())
+	}
+	var win textWindowResp
+	if err := json.NewDecoder(rr.Body).Decode(&win); err != nil {
+		t.Fatal(err)
+	}
+	if win.Offset != 4500 || len(win.Lines) == 0 || win.Lines[0].Text != "row 0500" {
+		t.Fatalf("window = %+v", win)
+	}
+
+	rr = httptest.NewRecorder()
+	rawWindow(rr, httptest.NewRequest("GET", "/api/raw-window?offset=4500&limit=9", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "row 0500\n" {
+		t.Fatalf("raw window = %d %q", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	searchLines(rr, httptest.NewRequest("GET", "/api/search?q=row+0700&offset=0&maxBytes=100000", nil))
+	var huge hugeSearchResp
+	if err := json.NewDecoder(rr.Body).Decode(&huge); err != nil {
+		t.Fatal(err)
+	}
+	if len(huge.Items) != 1 || huge.Items[0].Offset != 6300 {
+		t.Fatalf("byte search after upgrade = %+v", huge)
+	}
+}
+
+func TestRequestsReportFileChangedOnDisk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "changing.log")
+	if err := os.WriteFile(path, []byte("alpha\nbeta\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := indexer.Open(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useFile(t, f)
+
+	appendTo := func(text string) {
+		handle, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer handle.Close()
+		if _, err := handle.WriteString(text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appendTo("gamma\n")
+	rr := httptest.NewRecorder()
+	chunk(rr, httptest.NewRequest("GET", "/api/chunk?start=0&count=10", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get(fileChangeHeader) != "appended" {
+		t.Fatalf("after append: status %d, change %q", rr.Code, rr.Header().Get(fileChangeHeader))
+	}
+
+	if err := os.WriteFile(path, []byte("ALPHA\nBETA\nGAMMA\nDELTA\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	chunk(rr, httptest.NewRequest("GET", "/api/chunk?start=0&count=10", nil))
+	var changed fileChangedResp
+	if err := json.NewDecoder(rr.Body).Decode(&changed); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusConflict || changed.Change != "rewritten" {
+		t.Fatalf("after rewrite: status %d, body %+v", rr.Code, changed)
+	}
+
+	rr = httptest.NewRecorder()
+	chunk(rr, httptest.NewRequest("GET", "/api/chunk?start=0&count=10&stale=1", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("pinned read: status %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	fileState(rr, httptest.NewRequest("GET", "/api/file-state", nil))
+	var st fileStateResp
+	if err := json.NewDecoder(rr.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Change != "rewritten" || !st.Stale || st.Lines != 2 {
+		t.Fatalf("file state = %+v", st)
+	}
+}
+
+func TestHexWindowAndBinaryRows(t *testing.T) {
+	body := "plain text\nframe \x00\x01\x02\xff end\n\x1b[31mred\x1b[0m\n"
+	f, err := indexer.OpenSource(context.Background(), indexer.NewMemorySource("mixed.log", []byte(body)), indexer.OpenOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useFile(t, f)
+
+	rr := httptest.NewRecorder()
+	chunk(rr, httptest.NewRequest("GET", "/api/chunk?start=0&count=10&details=1", nil))
+	var rows chunkResp
+	if err := json.NewDecoder(rr.Body).Decode(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows.Binary) != 1 || rows.Binary[0] != 1 {
+		t.Fatalf("binary rows = %v, want [1]", rows.Binary)
+	}
+
+	rr = httptest.NewRecorder()
+	hexWindow(rr, httptest.NewRequest("GET", "/api/hex-window?line=1&limit=16", nil))
+	var hex hexWindowResp
+	if err := json.NewDecoder(rr.Body).Decode(&hex); err != nil {
+		t.Fatal(err)
+	}
+	want := hexRow{Offset: 0, Hex: "70 6c 61 69 6e 20 74 65  78 74 0a 66 72 61 6d 65", ASCII: "plain text.frame"}
+	if hex.Offset != 0 || hex.NextOffset != 16 || len(hex.Rows) != 1 || hex.Rows[0] != want {
+		t.Fatalf("hex window = %+v", hex)
+	}
+
+	rr = httptest.NewRecorder()
+	hexWindow(rr, httptest.NewRequest("GET", "/api/hex-window?offset=20", nil))
+	hex = hexWindowResp{}
+	if err := json.NewDecoder(rr.Body).Decode(&hex); err != nil {
+		t.Fatal(err)
+	}
+	if hex.Offset != 16 || hex.NextOffset != int64(len(body)) || hex.Rows[0].ASCII != " .... end..[31mr" {
+		t.Fatalf("hex window at 20 = %+v", hex)
+	}
+}
+
+func TestListDirBinaryOptIn(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "notes"), []byte("hello\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "frames"), []byte("hdr\x00\x01\x02"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	oldRoot := rootDir
+	rootDir = dir
+	setExtensions([]string{"*"}, "merge")
+	t.Cleanup(func() {
+		rootDir = oldRoot
+		setExtensions(defaultExt, "replace")
+	})
+
+	list := func(query string) []byte {
+		rr := httptest.NewRecorder()
+		listDir(rr, httptest.NewRequest("GET", "/api/list"+query, nil))
+		return rr.Body.Bytes()
+	}
+	var names []string
+	if err := json.Unmarshal(list(""), &names); err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "notes" {
+		t.Fatalf("default list = %v", names)
+	}
+
+	var resp struct {
+		Files []struct {
+			Path   string `json:"path"`
+			Binary bool   `json:"binary"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal(list("?details=1&binary=1"), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Files) != 2 || resp.Files[0].Path != "frames" || !resp.Files[0].Binary || resp.Files[1].Binary {
+		t.Fatalf("binary list = %+v", resp.Files)
+	}
+}
+
+func TestHandlesKeepSeveralFilesOpen(t *testing.T) {
+	dir := t.TempDir()
+	for name, body := range map[string]string{"a.log": "alpha\n", "b.log": "beta\nbeta\n", "c.log": "gamma\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldRoot, oldMax := rootDir, maxHandles
+	rootDir, maxHandles = dir, 2
+	isolateHandles(t)
+	t.Cleanup(func() { rootDir, maxHandles = oldRoot, oldMax })
+
+	open := func(query string) openResult {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		openFile(rr, httptest.NewRequest("GET", "/api/open?"+query, nil))
+		var res openResult
+		if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+			t.Fatalf("open %s: status %d: %v", query, rr.Code, err)
+		}
+		return res
+	}
+	chunkOf := func(query string) (int, []string) {
+		rr := httptest.NewRecorder()
+		chunk(rr, httptest.NewRequest("GET", "/api/chunk?"+query, nil))
+		var lines []string
+		_ = json.NewDecoder(rr.Body).Decode(&lines)
+		return rr.Code, lines
+	}
+
+	a := open("path=a.log")
+	b := open("path=b.log&handle=new")
+	if a.Handle == "" || b.Handle == "" || a.Handle == b.Handle {
+		t.Fatalf("handles = %q, %q", a.Handle, b.Handle)
+	}
+	if _, lines := chunkOf("handle=" + b.Handle); len(lines) != 2 || lines[0] != "beta\n" {
+		t.Fatalf("chunk of b = %q", lines)
+	}
+	if _, lines := chunkOf(""); len(lines) != 1 || lines[0] != "alpha\n" {
+		t.Fatalf("default chunk = %q, want a.log", lines)
+	}
+
+	// A third handle pushes out b, the least recently used.
+	mu.Lock()
+	handles[b.Handle].used.Store(1)
+	mu.Unlock()
+	c := open("path=c.log&handle=new")
+	if code, _ := chunkOf("handle=" + b.Handle); code != http.StatusNotFound {
+		t.Fatalf("evicted handle status = %d, want 404", code)
+	}
+	if _, lines := chunkOf("handle=" + c.Handle); len(lines) != 1 || lines[0] != "gamma\n" {
+		t.Fatalf("chunk of c = %q", lines)
+	}
+
+	// Idle handles expire, except the default one.
+	mu.Lock()
+	sweepHandles(time.Now().Add(handleIdleTimeout + time.Minute))
+	_, kept := handles[a.Handle]
+	_, expired := handles[c.Handle]
+	mu.Unlock()
+	if !kept || expired {
+		t.Fatalf("after sweep: default kept=%v, idle kept=%v", kept, expired)
+	}
+
+	rr := httptest.NewRecorder()
+	closeFile(rr, httptest.NewRequest("POST", "/api/close", nil))
+	if code, _ := chunkOf(""); rr.Code != http.StatusNoContent || code != http.StatusBadRequest {
+		t.Fatalf("close status = %d, chunk after close = %d", rr.Code, code)
+	}
+}
+
+func TestReopeningRecentFileSkipsIndexing(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.log", "b.log", "c.log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldRoot, oldMax := rootDir, recentFilesMax
+	rootDir, recentFilesMax = dir, 1
+	isolateHandles(t)
+	t.Cleanup(func() { rootDir, recentFilesMax = oldRoot, oldMax })
+
+	open := func(name string) *indexer.File {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		openFile(rr, httptest.NewRequest("GET", "/api/open?path="+name, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("open %s: status %d: %s", name, rr.Code, rr.Body.String())
+		}
+		mu.RLock()
+		defer mu.RUnlock()
+		return defaultHandle.file
+	}
+
+	a := open("a.log")
+	open("b.log")
+	if again := open("a.log"); again != a {
+		t.Fatal("reopening a.log indexed it again")
+	}
+
+	// Only one parked file fits, so parking b.log closes a.log.
+	open("b.log")
+	open("c.log")
+	if a.Src != nil {
+		t.Fatal("evicted file is still open")
+	}
+
+	later := time.Now().Add(time.Minute)
+	if err := os.WriteFile(filepath.Join(dir, "b.log"), []byte("b.log\nmore\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Chtimes(filepath.Join(dir, "c.log"), later, later)
+	open("b.log")
+	if c := open("c.log"); c.Lines != 1 || c.Src == nil {
+		t.Fatalf("c.log after touch = %+v", c)
+	}
+	if b := open("b.log"); b.Lines != 2 {
+		t.Fatalf("changed b.log has %d lines, want 2", b.Lines)
+	}
+}
+
+func TestSearchStreamSendsMatchesProgressAndTotal(t *testing.T) {
+	var body strings.Builder
+	for i := 0; i < 3000; i++ {
+		if i%10 == 0 {
+			fmt.Fprintf(&body, "row %d needle ERROR\n", i)
+		} else {
+			fmt.Fprintf(&body, "row %d hay\n", i)
+		}
+	}
+	oldMax, oldCount := indexer.MaxIndexedBytes, countLinesInBackground
+	t.Cleanup(func() { indexer.MaxIndexedBytes, countLinesInBackground = oldMax, oldCount })
+	countLinesInBackground = false
+
+	type event struct {
+		name string
+		data string
+	}
+	stream := func(query string) []event {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		searchStreamHandler(rr, httptest.NewRequest("GET", "/api/search/stream?"+query, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rr.Code, rr.Body.String())
+		}
+		var events []event
+		for _, block := range strings.Split(strings.TrimSpace(rr.Body.String()), "\n\n") {
+			lines := strings.SplitN(block, "\n", 2)
+			events = append(events, event{strings.TrimPrefix(lines[0], "event: "), strings.TrimPrefix(lines[1], "data: ")})
+		}
+		return events
+	}
+
+	for _, mode := range []string{indexer.ModeLine, indexer.ModeByte} {
+		indexer.MaxIndexedBytes = oldMax
+		if mode == indexer.ModeByte {
+			indexer.MaxIndexedBytes = 16
+		}
+		f, err := indexer.OpenSource(context.Background(), indexer.NewMemorySource("stream.log", []byte(body.String())), indexer.OpenOptions{}, nil)
+		if err != nil || f.Mode != mode {
+			t.Fatalf("open in %s mode: %v", mode, err)
+		}
+		useFile(t, f)
+
+		events := stream("q=NEEDLE&limit=5")
+		var matches []searchMatchEvent
+		for _, ev := range events[:len(events)-1] {
+			if ev.name != "match" {
+				continue
+			}
+			var m searchMatchEvent
+			if err := json.Unmarshal([]byte(ev.data), &m); err != nil {
+				t.Fatal(err)
+			}
+			matches = append(matches, m)
+		}
+		last := events[len(events)-1]
+		var done searchStreamStatus
+		if err := json.Unmarshal([]byte(last.data), &done); err != nil || last.name != "done" {
+			t.Fatalf("%s: last event = %+v", mode, last)
+		}
+		if len(matches) != 5 || strings.TrimSpace(matches[1].Text) != "row 10 needle ERROR" || matches[1].Tone != "error" {
+			t.Fatalf("%s: matches = %+v", mode, matches)
+		}
+		if mode == indexer.ModeLine && matches[1].Line != 10 || mode == indexer.ModeByte && matches[1].Line != -1 {
+			t.Fatalf("%s: second match = %+v", mode, matches[1])
+		}
+		if done.Total != 300 || done.Sent != 5 || done.Percent != 100 || done.Scanned != done.Size {
+			t.Fatalf("%s: done = %+v", mode, done)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rr := httptest.NewRecorder()
+	searchStreamHandler(rr, httptest.NewRequest("GET", "/api/search/stream?q=needle", nil).WithContext(ctx))
+	if strings.Contains(rr.Body.String(), "event: done") {
+		t.Fatal("search kept going after the client left")
+	}
+}
+
+func TestParallelSearchMatchesSequentialScan(t *testing.T) {
+	oldRows, oldBytes, oldWorkers := searchRangeRows, searchRangeBytes, searchWorkers
+	searchRangeRows, searchRangeBytes, searchWorkers = indexer.Group, 100, 4
+	t.Cleanup(func() { searchRangeRows, searchRangeBytes, searchWorkers = oldRows, oldBytes, oldWorkers })
+
+	// Row 255 is a line split over rows 255 and 256, the first range
+	// boundary, with the needle in its second half.
+	var body strings.Builder
+	for i := 0; i < 255; i++ {
+		fmt.Fprintf(&body, "line %d needle=%v\n", i, i%7 == 0)
+	}
+	body.WriteString(strings.Repeat("x", int(indexer.MaxIndexedLineBytes)) + " needle=true\n")
+	for i := 256; i < 700; i++ {
+		fmt.Fprintf(&body, "line %d needle=%v\n", i, i%7 == 0)
+	}
+	f, err := indexer.OpenSource(context.Background(), indexer.NewMemorySource("par.log", []byte(body.String())), indexer.OpenOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	matcher, _ := newTextMatcher("needle=true", false, false)
+
+	for _, limit := range []int{1, 5, 500} {
+		var want []int
+		wantTotal := 0
+		joiner := splitLineJoiner{row: -1}
+		emit := func(row int, text string) {
+			if matcher(text) {
+				wantTotal++
+				if len(want) < limit {
+					want = append(want, row)
+				}
+			}
+		}
+		rows, _ := f.SliceRows(0, f.Lines)
+		joiner.add(0, rows, emit)
+		joiner.flush(emit)
+
+		got, total, err := searchLineRanges(f, matcher, limit)
+		if err != nil || total != wantTotal || fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("limit %d: got %v (%d), %v; want %v (%d)", limit, got, total, err, want, wantTotal)
+		}
+	}
+
+	small := strings.Repeat("alpha needle\nbeta\ngamma needle again\n", 40)
+	g, err := indexer.OpenSource(context.Background(), indexer.NewMemorySource("bytes.log", []byte(small)), indexer.OpenOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	keep := func(row textWindowLine) bool { return strings.Contains(row.Text, "needle") }
+	for _, limit := range []int{1, 7, 1000} {
+		for _, maxBytes := range []int64{50, 333, int64(len(small))} {
+			want, wantNext, wantMore, _ := scanCleanRows(g, 12, maxBytes, limit, keep, false)
+			got, next, more, err := scanByteRanges(g, 12, maxBytes, limit, keep)
+			if err != nil || next != wantNext || more != wantMore || fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("limit %d, %d bytes: got %d rows to %d (%v); want %d rows to %d (%v)",
+					limit, maxBytes, len(got), next, more, len(want), wantNext, wantMore)
+			}
+		}
+	}
+}
+
+func TestQuerySearchCombinesTerms(t *testing.T) {
+	cases := []struct {
+		query string
+		line  string
+		want  bool
+	}{
+		{"StudentID=34046544 AND NOT INFO", "ERROR StudentID=34046544 saved", true},
+		{"StudentID=34046544 AND NOT INFO", "INFO StudentID=34046544 saved", false},
+		{"(timeout OR denied) AND SchoolCode=51", "access DENIED SchoolCode=51", true},
+		{"(timeout OR denied) AND SchoolCode=51", "timeout SchoolCode=52", false},
+		{"timeout SchoolCode=51", "SchoolCode=51 timeout", true},
+		{`"request failed" OR nothing`, "the Request Failed twice", true},
+		{`case:"request failed"`, "the Request Failed twice", false},
+		{`/code=\d{3}\b/ AND NOT /CODE=500/c`, "code=404", true},
+		{`/code=\d{3}\b/ AND NOT /CODE=500/c`, "CODE=500", false},
+		{"tone:error AND NOT tone:warn", "job failed after retry", true},
+		{"tone:warning", "retry scheduled", true},
+		{"NOT NOT tone:ok", "upload completed", true},
+	}
+	for _, c := range cases {
+		q, err := parseQuery(c.query, false)
+		if err != nil {
+			t.Fatalf("%s: %v", c.query, err)
+		}
+		if got := q.Match(c.line); got != c.want {
+			t.Fatalf("%s on %q = %v, want %v", c.query, c.line, got, c.want)
+		}
+	}
+
+	for query, col := range map[string]int{
+		"":                   1,
+		"(timeout OR denied": 1,
+		"a AND":              6,
+		"OR b":               1,
+		"a )":                3,
+		`x "open phrase`:     3,
+		"x /[/":              3,
+		"/ok/q":              5,
+		"tone:loud":          1,
+		"a AND case:":        7,
+	} {
+		_, err := parseQuery(query, false)
+		var qe *queryError
+		if !errors.As(err, &qe) || qe.Col != col {
+			t.Fatalf("%q: error %v, want one at column %d", query, err, col)
+		}
+	}
+
+	body := "INFO StudentID=7 ok\nERROR StudentID=7 timeout\nERROR StudentID=8 timeout\n"
+	f, err := indexer.OpenSource(context.Background(), indexer.NewMemorySource("q.log", []byte(body)), indexer.OpenOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useFile(t, f)
+	rr := httptest.NewRecorder()
+	searchLines(rr, httptest.NewRequest("GET", "/api/search?query=1&q="+url.QueryEscape("StudentID=7 AND NOT INFO"), nil))
+	var lines struct {
+		Matches []int `json:"Matches"`
+		Total   int   `json:"Total"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&lines); err != nil {
+		t.Fatal(err)
+	}
+	if lines.Total != 1 || fmt.Sprint(lines.Matches) != "[1]" {
+		t.Fatalf("line-mode query = %+v, want row 1", lines)
+	}
+
+	rr = httptest.NewRecorder()
+	searchLines(rr, httptest.NewRequest("GET", "/api/search?query=1&q="+url.QueryEscape("(timeout OR"), nil))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "column 12") {
+		t.Fatalf("malformed query = %d %q, want 400 at column 12", rr.Code, rr.Body.String())
+	}
+
+	// Byte-mode rows carry the tone they were cleaned with.
+	g := &indexer.File{Path: "q.log", Src: indexer.NewMemorySource("q.log", []byte(body)), Size: int64(len(body)), Mode: indexer.ModeByte}
+	req := httptest.NewRequest("GET", "/api/search?query=1&q="+url.QueryEscape("tone:error AND NOT StudentID=8"), nil)
+	_, keep, err := requestMatcher(req, g, req.URL.Query().Get("q"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	huge, err := searchHugeFile(req, g, keep, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(huge.Items) != 1 || huge.Items[0].Offset != int64(strings.Index(body, "ERROR StudentID=7")) {
+		t.Fatalf("byte-mode query = %+v, want the StudentID=7 error line", huge.Items)
+	}
+}
+
+func TestSearchRootGroupsMatchesByFile(t *testing.T) {
+	dir := t.TempDir()
+	oldRoot := rootDir
+	rootDir = dir
+	setExtensions(defaultExt, "replace")
+	t.Cleanup(func() {
+		rootDir = oldRoot
+		setExtensions(defaultExt, "replace")
+	})
+	write := func(name, body string, age time.Duration) {
+		t.Helper()
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasSuffix(name, ".gz") {
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			gz.Write([]byte(body))
+			gz.Close()
+			body = buf.String()
+		}
+		if err := os.WriteFile(p, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		mod := time.Now().Add(-age)
+		if err := os.Chtimes(p, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("jobs/a.log", "start\nStudentID=42 timeout\nok\n", 0)
+	write("jobs/b.log.gz", "StudentID=42 done\nStudentID=42 again\n", 48*time.Hour)
+	write("c.txt", "nothing here\n", 0)
+	write("d.bin", "StudentID=42\n", 0)
+
+	stream := func(query string) (map[string]rootSearchFile, rootSearchStatus) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		searchRootHandler(rr, httptest.NewRequest("GET", "/api/search/root?"+query, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", query, rr.Code, rr.Body.String())
+		}
+		files := map[string]rootSearchFile{}
+		var done rootSearchStatus
+		for _, block := range strings.Split(strings.TrimSpace(rr.Body.String()), "\n\n") {
+			lines := strings.SplitN(block, "\n", 2)
+			data := []byte(strings.TrimPrefix(lines[1], "data: "))
+			switch strings.TrimPrefix(lines[0], "event: ") {
+			case "file":
+				var f rootSearchFile
+				if err := json.Unmarshal(data, &f); err != nil {
+					t.Fatal(err)
+				}
+				files[f.Path] = f
+			case "done":
+				if err := json.Unmarshal(data, &done); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		return files, done
+	}
+
+	files, done := stream("q=studentid%3D42")
+	if len(files) != 2 || done.Files != 3 || done.Searched != 3 || done.MatchedFiles != 2 || done.Total != 3 {
+		t.Fatalf("files = %+v, done = %+v", files, done)
+	}
+	a := files["jobs/a.log"]
+	if len(a.Matches) != 1 || a.Matches[0].Line != 1 || a.Matches[0].Offset != 6 || a.Matches[0].Tone != "error" {
+		t.Fatalf("a.log = %+v", a)
+	}
+	b := files["jobs/b.log.gz"]
+	if !b.Compressed || b.Total != 2 || b.Matches[1].Text != "StudentID=42 again" {
+		t.Fatalf("b.log.gz = %+v", b)
+	}
+
+	files, _ = stream("q=StudentID&limit=1&glob=*.gz")
+	if len(files) != 1 || files["jobs/b.log.gz"].Total != 2 || len(files["jobs/b.log.gz"].Matches) != 1 {
+		t.Fatalf("glob files = %+v", files)
+	}
+	since := time.Now().Add(-time.Hour).UnixMilli()
+	files, done = stream(fmt.Sprintf("q=StudentID&since=%d", since))
+	if len(files) != 1 || files["jobs/a.log"].Total != 1 || done.Files != 2 {
+		t.Fatalf("since files = %+v, done = %+v", files, done)
+	}
+
+	rr := httptest.NewRecorder()
+	searchRootHandler(rr, httptest.NewRequest("GET", "/api/search/root?q=x&glob=%5B", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("bad glob status = %d", rr.Code)
+	}
+
+	// A line cut into rows is matched whole and later lines keep the row
+	// numbers the viewer gives them.
+	long := strings.Repeat("x", 2*int(indexer.MaxIndexedLineBytes)+10) + "needle\n"
+	write("long.log", "a\n"+long+"needle\n", 0)
+	files, _ = stream("q=needle&glob=long.log")
+	if m := files["long.log"].Matches; len(m) != 2 || m[0].Line != 1 || m[1].Line != 4 || m[1].Offset != int64(2+len(long)) {
+		t.Fatalf("long.log = %+v", files["long.log"])
+	}
+	lf, err := indexer.Open(context.Background(), filepath.Join(dir, "long.log"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lf.Close()
+	if rows, err := lf.SliceRows(4, 1); err != nil || len(rows) != 1 || rows[0].Text != "needle\n" {
+		t.Fatalf("row 4 = %+v, %v", rows, err)
+	}
+
+	// A line ending set for a file applies to its search too.
+	write("cr.log", "a\rneedle\n", 0)
+	files, _ = stream("q=needle&glob=cr.log")
+	if m := files["cr.log"].Matches; len(m) != 1 || m[0].Line != 1 {
+		t.Fatalf("detected cr.log = %+v", files["cr.log"])
+	}
+	crPath := filepath.Join(dir, "cr.log")
+	if _, err := openOptionsFor(crPath, url.Values{"lineEnding": {"lf"}}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { openOptionsFor(crPath, url.Values{"lineEnding": {"auto"}}) })
+	files, _ = stream("q=needle&glob=cr.log")
+	if m := files["cr.log"].Matches; len(m) != 1 || m[0].Line != 0 || m[0].Text != "a\rneedle" {
+		t.Fatalf("overridden cr.log = %+v", files["cr.log"])
+	}
+
+	// HTML logs match and show their cleaned text unless match=raw.
+	write("run.html", "<font color=\"red\">job <b>failed</b></font><br>\n<span class=\"needle\">ok</span>\n", 0)
+	files, _ = stream("q=job+failed&glob=*.html")
+	if m := files["run.html"].Matches; len(m) != 1 || m[0].Line != 0 || m[0].Text != "job failed" || m[0].Tone != "error" {
+		t.Fatalf("clean run.html = %+v", files["run.html"])
+	}
+	if files, _ = stream("q=needle&glob=*.html"); len(files) != 0 {
+		t.Fatalf("markup matched: %+v", files)
+	}
+	files, _ = stream("q=needle&glob=*.html&match=raw")
+	if m := files["run.html"].Matches; len(m) != 1 || m[0].Line != 1 || m[0].Text != `<span class="needle">ok</span>` {
+		t.Fatalf("raw run.html = %+v", files["run.html"])
+	}
+	rr = httptest.NewRecorder()
+	searchRootHandler(rr, httptest.NewRequest("GET", "/api/search/root?q=x&match=html", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("bad match status = %d", rr.Code)
+	}
+}
+
+func TestSearchContextMergesOverlappingWindows(t *testing.T) {
+	body := "a0\na1\n<b>needle</b> x<br>tail\na3\na4\na5\nneedle two\na7\n"
+	oldMax, oldCount := indexer.MaxIndexedBytes, countLinesInBackground
+	t.Cleanup(func() { indexer.MaxIndexedBytes, countLinesInBackground = oldMax, oldCount })
+	countLinesInBackground = false
+
+	texts := func(blocks []searchContext) string {
+		var out []string
+		for _, b := range blocks {
+			var lines []string
+			for _, l := range b.Lines {
+				if l.Match {
+					lines = append(lines, "*"+l.Text)
+				} else {
+					lines = append(lines, l.Text)
+				}
+			}
+			out = append(out, strings.Join(lines, ","))
+		}
+		return strings.Join(out, " | ")
+	}
+	search := func(query string, v any) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		searchLines(rr, httptest.NewRequest("GET", "/api/search?q=needle&"+query, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", query, rr.Code, rr.Body.String())
+		}
+		if err := json.NewDecoder(rr.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := indexer.OpenSource(context.Background(), indexer.NewMemorySource("ctx.log", []byte(body)), indexer.OpenOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useFile(t, f)
+	var lines lineSearchResp
+	search("before=1&after=1", &lines)
+	if got, want := texts(lines.Context), "a1,*<b>needle</b> x<br>tail,a3 | a5,*needle two,a7"; got != want {
+		t.Fatalf("line context = %q, want %q", got, want)
+	}
+	if lines.Context[1].Lines[1].Line != 6 {
+		t.Fatalf("line context rows = %+v", lines.Context[1].Lines)
+	}
+	lines = lineSearchResp{}
+	search("before=2&after=2&text=clean", &lines)
+	if got, want := texts(lines.Context), "a0,a1,*needle x tail,a3,a4,a5,*needle two,a7"; got != want {
+		t.Fatalf("merged clean context = %q, want %q", got, want)
+	}
+	lines = lineSearchResp{}
+	search("", &lines)
+	if lines.Context != nil || lines.Total != 2 {
+		t.Fatalf("search without context = %+v", lines)
+	}
+
+	// HTML logs are matched cleaned, so their context is cleaned too.
+	h, err := indexer.OpenSource(context.Background(), indexer.NewMemorySource("ctx.html", []byte(body)), indexer.OpenOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useFile(t, h)
+	lines = lineSearchResp{}
+	search("before=1&after=1", &lines)
+	if got, want := texts(lines.Context), "a1,*needle x tail,a3 | a5,*needle two,a7"; got != want {
+		t.Fatalf("html context = %q, want %q", got, want)
+	}
+	lines = lineSearchResp{}
+	search("before=1&after=1&match=raw", &lines)
+	if got, want := texts(lines.Context), "a1,*<b>needle</b> x<br>tail,a3 | a5,*needle two,a7"; got != want {
+		t.Fatalf("raw-matched html context = %q, want %q", got, want)
+	}
+
+	indexer.MaxIndexedBytes = 16
+	g, err := indexer.OpenSource(context.Background(), indexer.NewMemorySource("ctx.log", []byte(body)), indexer.OpenOptions{}, nil)
+	if err != nil || g.Mode != indexer.ModeByte {
+		t.Fatalf("open in byte mode: %v", err)
+	}
+	useFile(t, g)
+	var huge hugeSearchResp
+	search("before=1&after=1", &huge)
+	if got, want := texts(huge.Context), "a1,*needle x,tail | a5,*needle two,a7"; got != want {
+		t.Fatalf("byte context = %q, want %q", got, want)
+	}
+	huge = hugeSearchResp{}
+	search("before=1&after=2", &huge)
+	if got, want := texts(huge.Context), "a1,*needle x,tail,a3 | a5,*needle two,a7"; got != want {
+		t.Fatalf("byte context = %q, want %q", got, want)
+	}
+	huge = hugeSearchResp{}
+	search("before=2&after=2", &huge)
+	if got, want := texts(huge.Context), "a0,a1,*needle x,tail,a3,a4,a5,*needle two,a7"; got != want {
+		t.Fatalf("touching byte context = %q, want %q", got, want)
+	}
+	huge = hugeSearchResp{}
+	search("before=1&after=1&text=raw", &huge)
+	if got, want := texts(huge.Context), "a1,*<b>needle</b> x<br>tail,a3 | a5,*needle two,a7"; got != want {
+		t.Fatalf("raw byte context = %q, want %q", got, want)
+	}
+	if l := huge.Context[1].Lines[1]; l.Line != -1 || l.Offset != int64(strings.Index(body, "needle two")) {
+		t.Fatalf("raw byte match = %+v", l)
+	}
+}
+
+func TestMatchHistogramCountsPerBucketAndCaches(t *testing.T) {
+	var body strings.Builder
+	for i := 0; i < 1000; i++ {
+		if i >= 900 || i%100 == 0 {
+			fmt.Fprintf(&body, "%04d ERROR boom\n", i)
+		} else {
+			fmt.Fprintf(&body, "%04d INFO quiet\n", i)
+		}
+	}
+	oldMax, oldCount := indexer.MaxIndexedBytes, countLinesInBackground
+	t.Cleanup(func() { indexer.MaxIndexedBytes, countLinesInBackground = oldMax, oldCount })
+	countLinesInBackground = false
+
+	histogram := func(query string) histogramResp {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		matchHistogram(rr, httptest.NewRequest("GET", "/api/search/histogram?"+query, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", query, rr.Code, rr.Body.String())
+		}
+		var resp histogramResp
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	for _, mode := range []string{indexer.ModeLine, indexer.ModeByte} {
+		indexer.MaxIndexedBytes = oldMax
+		if mode == indexer.ModeByte {
+			indexer.MaxIndexedBytes = 16
+		}
+		f, err := indexer.OpenSource(context.Background(), indexer.NewMemorySource("hist-"+mode+".log", []byte(body.String())), indexer.OpenOptions{}, nil)
+		if err != nil || f.Mode != mode {
+			t.Fatalf("open in %s mode: %v", mode, err)
+		}
+		useFile(t, f)
+
+		resp := histogram("q=error&buckets=10")
+		want := "[1 1 1 1 1 1 1 1 1 100]"
+		if fmt.Sprint(resp.Counts) != want || resp.Total != 109 || resp.Max != 100 || resp.Cached {
+			t.Fatalf("%s: histogram = %+v, want counts %s", mode, resp, want)
+		}
+		if mode == indexer.ModeLine && (resp.Unit != "lines" || resp.Size != 1000) || mode == indexer.ModeByte && (resp.Unit != "bytes" || resp.Size != int64(body.Len())) {
+			t.Fatalf("%s: unit %q size %d", mode, resp.Unit, resp.Size)
+		}
+		if again := histogram("q=error&buckets=10"); !again.Cached || fmt.Sprint(again.Counts) != want {
+			t.Fatalf("%s: repeat = %+v, want it cached", mode, again)
+		}
+		if other := histogram("q=error+AND+NOT+0000&query=1&buckets=5"); other.Cached || fmt.Sprint(other.Counts) != "[1 2 2 2 101]" {
+			t.Fatalf("%s: query histogram = %+v", mode, other)
+		}
+	}
+}
+
+func TestLineSearchMatchesCleanedHTML(t *testing.T) {
+	body := `<font color="blue">INFO started job</font><br>` + "\n" +
+		`<font color="red">ERROR access <b>denied</b> for user</font><br>` + "\n" +
+		"plain line\n"
+	search := func(name, query string) lineSearchResp {
+		t.Helper()
+		f, err := indexer.OpenSource(context.Background(), indexer.NewMemorySource(name, []byte(body)), indexer.OpenOptions{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		useFile(t, f)
+		rr := httptest.NewRecorder()
+		searchLines(rr, httptest.NewRequest("GET", "/api/search?"+query, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s %s: status = %d: %s", name, query, rr.Code, rr.Body.String())
+		}
+		var resp lineSearchResp
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	cases := []struct {
+		name, query string
+		want        string
+	}{
+		{"connect.html", "q=color", "[]"},
+		{"connect.html", "q=color&match=raw", "[0 1]"},
+		{"connect.html", "q=access+denied+for", "[1]"},
+		{"connect.html", "q=access+denied+for&match=raw", "[]"},
+		{"connect.html", "q=tone%3Aerror&query=1", "[1]"},
+		{"connect.log", "q=color", "[0 1]"},
+		{"connect.log", "q=access+denied+for&match=clean", "[1]"},
+	}
+	for _, c := range cases {
+		if got := fmt.Sprint(search(c.name, c.query).Matches); got != c.want {
+			t.Fatalf("%s %s: matches = %s, want %s", c.name, c.query, got, c.want)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	searchLines(rr, httptest.NewRequest("GET", "/api/search?q=x&match=html", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("bad match param status = %d", rr.Code)
+	}
+}
+
+// isolateHandles gives the test an empty set of handles and closes whatever
+// it opened when it ends.
+func isolateHandles(t *testing.T) {
+	t.Helper()
+	mu.Lock()
+	oldHandles, oldDefault := handles, defaultHandle
+	handles, defaultHandle = map[string]*fileHandle{}, nil
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		closeAllHandles()
+		handles, defaultHandle = oldHandles, oldDefault
+		mu.Unlock()
+		closeRecentFiles()
+	})
+}
+
+// useFile opens f in the default handle for the rest of the test.
+func useFile(t *testing.T, f *indexer.File) *fileHandle {
+	t.Helper()
+	isolateHandles(t)
+	mu.Lock()
+	defer mu.Unlock()
+	return installFile("", f)
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tm-LBenson/big-log-viewer/internal/indexer"
+)
+
+const openJobIdleTimeout = 15 * time.Second
+const openJobRetention = time.Minute
+const openEventInterval = 250 * time.Millisecond
+
+var errOpenSuperseded = errors.New("open cancelled because another file was opened")
+
+type openResult struct {
+	Handle     string `json:"Handle"`
+	Lines      int    `json:"Lines"`
+	Size       int64  `json:"Size"`
+	Mode       string `json:"Mode"`
+	ChunkSize  int64  `json:"ChunkSize,omitempty"`
+	Encoding   string `json:"Encoding,omitempty"`
+	LineEnding string `json:"LineEnding,omitempty"`
+}
+
+type openStatus struct {
+	ID      string      `json:"id"`
+	Path    string      `json:"path"`
+	State   string      `json:"state"`
+	Phase   string      `json:"phase,omitempty"`
+	Bytes   int64       `json:"bytes"`
+	Total   int64       `json:"total"`
+	Lines   int         `json:"lines"`
+	Percent float64     `json:"percent"`
+	Error   string      `json:"error,omitempty"`
+	Result  *openResult `json:"result,omitempty"`
+}
+
+// openJob opens Path into the handle named by Target, which takes the same
+// values as /api/open's handle param.
+type openJob struct {
+	ID      string
+	Path    string
+	Options indexer.OpenOptions
+	Target  string
+
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+
+	mu       sync.Mutex
+	state    string
+	progress indexer.Progress
+	result   *openResult
+	err      error
+	lastSeen time.Time
+	ended    time.Time
+}
+
+var (
+	openJobsMu sync.Mutex
+	openJobs   = map[string]*openJob{}
+
+	openOverridesMu sync.Mutex
+	openOverrides   = map[string]indexer.OpenOptions{}
+)
+
+// startOpen begins opening abs in the background, cancelling earlier opens
+// into the same handle.
+func startOpen(parent context.Context, abs string, opts indexer.OpenOptions, target string) *openJob {
+	ctx, cancel := context.WithCancelCause(parent)
+	job := &openJob{
+		ID:       newOpaqueID(),
+		Path:     abs,
+		Options:  opts,
+		Target:   target,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		state:    "running",
+		lastSeen: time.Now(),
+	}
+
+	openJobsMu.Lock()
+	for id, other := range openJobs {
+		if target != newHandleParam && other.Target == target {
+			other.cancel(errOpenSuperseded)
+		}
+		if other.finished() && time.Since(other.endedAt()) > openJobRetention {
+			delete(openJobs, id)
+		}
+	}
+	openJobs[job.ID] = job
+	openJobsMu.Unlock()
+
+	go job.run(ctx)
+	return job
+}
+
+func (j *openJob) run(ctx context.Context) {
+	defer close(j.done)
+	defer j.cancel(nil)
+
+	// A file closed recently and unchanged since needs no indexing.
+	var err error
+	f := takeRecentFile(j.Path, j.Options)
+	if f == nil {
+		f, err = indexer.OpenWith(ctx, j.Path, j.Options, func(p indexer.Progress) {
+			j.mu.Lock()
+			j.progress = p
+			j.mu.Unlock()
+		})
+	}
+	if err == nil && ctx.Err() != nil {
+		parkFile(f)
+		err = ctx.Err()
+	}
+	if err != nil {
+		if cause := context.Cause(ctx); cause != nil && ctx.Err() != nil {
+			err = cause
+		}
+		j.finish(nil, err)
+		return
+	}
+
+	mu.Lock()
+	h := installFile(j.Target, f)
+	mu.Unlock()
+	j.finish(&openResult{h.ID, f.Lines, f.Size, f.Mode, f.ChunkSize, f.Encoding, f.LineEnding}, nil)
+}
+
+func (j *openJob) finish(res *openResult, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.result = res
+	j.err = err
+	j.ended = time.Now()
+	switch {
+	case err == nil:
+		j.state = "done"
+	case errors.Is(err, context.Canceled) || errors.Is(err, errOpenSuperseded):
+		j.state = "cancelled"
+	default:
+		j.state = "error"
+	}
+}
+
+func (j *openJob) finished() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (j *openJob) endedAt() time.Time {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.ended
+}
+
+func (j *openJob) touch() {
+	j.mu.Lock()
+	j.lastSeen = time.Now()
+	j.mu.Unlock()
+}
+
+func (j *openJob) watchIdle() {
+	ticker := time.NewTicker(openJobIdleTimeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.done:
+			return
+		case <-ticker.C:
+			j.mu.Lock()
+			idle := time.Since(j.lastSeen)
+			j.mu.Unlock()
+			if idle > openJobIdleTimeout {
+				j.cancel(errors.New("open cancelled because the client stopped polling"))
+				return
+			}
+		}
+	}
+}
+
+func (j *openJob) status() openStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	out := openStatus{
+		ID:     j.ID,
+		Path:   j.Path,
+		State:  j.state,
+		Phase:  j.progress.Phase,
+		Bytes:  j.progress.Bytes,
+		Total:  j.progress.Total,
+		Lines:  j.progress.Lines,
+		Result: j.result,
+	}
+	if out.Total > 0 {
+		out.Percent = clampFloat(float64(out.Bytes)*100/float64(out.Total), 0, 100)
+	}
+	if j.state == "done" {
+		out.Percent = 100
+	}
+	if j.err != nil {
+		out.Error = j.err.Error()
+	}
+	return out
+}
+
+func getOpenJob(id string) (*openJob, bool) {
+	openJobsMu.Lock()
+	defer openJobsMu.Unlock()
+	job, ok := openJobs[id]
+	return job, ok
+}
+
+func openFile(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "path param required", 400)
+		return
+	}
+	abs, err := resolveLogPath(path)
+	if err != nil {
+		http.Error(w, err.Error(), 403)
+		return
+	}
+	opts, err := openOptionsFor(abs, r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Without a handle the file replaces the default handle's, as it did
+	// when only one file could be open. handle=new opens it alongside the
+	// others and handle=<id> reopens into that handle.
+	target := r.URL.Query().Get("handle")
+	if target != "" && target != newHandleParam {
+		mu.RLock()
+		_, ok := handles[target]
+		mu.RUnlock()
+		if !ok {
+			fileError(w, errHandleNotFound)
+			return
+		}
+	}
+
+	if r.URL.Query().Get("async") == "1" {
+		job := startOpen(context.Background(), abs, opts, target)
+		go job.watchIdle()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		writeJSON(w, job.status())
+		return
+	}
+
+	job := startOpen(r.Context(), abs, opts, target)
+	<-job.done
+	st := job.status()
+	switch st.State {
+	case "done":
+		writeJSON(w, st.Result)
+	case "cancelled":
+		http.Error(w, st.Error, http.StatusConflict)
+	default:
+		http.Error(w, st.Error, 500)
+	}
+}
+
+func openStatusHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := requireOpenJob(w, r)
+	if !ok {
+		return
+	}
+	job.touch()
+	writeJSON(w, job.status())
+}
+
+func openEvents(w http.ResponseWriter, r *http.Request) {
+	job, ok := requireOpenJob(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(openEventInterval)
+	defer ticker.Stop()
+	for {
+		job.touch()
+		st := job.status()
+		if st.State != "running" {
+			writeEvent(w, st.State, st)
+			flusher.Flush()
+			return
+		}
+		writeEvent(w, "progress", st)
+		flusher.Flush()
+		select {
+		case <-r.Context().Done():
+			job.cancel(errors.New("open cancelled because the client disconnected"))
+			return
+		case <-job.done:
+		case <-ticker.C:
+		}
+	}
+}
+
+func openCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "POST or DELETE only", http.StatusMethodNotAllowed)
+		return
+	}
+	job, ok := requireOpenJob(w, r)
+	if !ok {
+		return
+	}
+	job.cancel(context.Canceled)
+	<-job.done
+	writeJSON(w, job.status())
+}
+
+// openOptionsFor returns the options to open abs with. An encoding or
+// lineEnding param overrides detection for that path until it is set back
+// to "auto", and without one the last override for the path still applies.
+func openOptionsFor(abs string, query url.Values) (indexer.OpenOptions, error) {
+	openOverridesMu.Lock()
+	defer openOverridesMu.Unlock()
+	opts := openOverrides[abs]
+	if v, ok := query["encoding"]; ok {
+		enc, err := indexer.ParseEncoding(v[0])
+		if err != nil {
+			return indexer.OpenOptions{}, err
+		}
+		opts.Encoding = enc
+	}
+	if v, ok := query["lineEnding"]; ok {
+		ending, err := indexer.ParseLineEnding(v[0])
+		if err != nil {
+			return indexer.OpenOptions{}, err
+		}
+		opts.LineEnding = ending
+	}
+	if opts == (indexer.OpenOptions{}) {
+		delete(openOverrides, abs)
+	} else {
+		openOverrides[abs] = opts
+	}
+	return opts, nil
+}
+
+func requireOpenJob(w http.ResponseWriter, r *http.Request) (*openJob, bool) {
+	id := strings.TrimSpace(r.URL.Query().Get("id"))
+	if id == "" {
+		http.Error(w, "id param required", http.StatusBadRequest)
+		return nil, false
+	}
+	job, ok := getOpenJob(id)
+	if !ok {
+		http.Error(w, fmt.Sprintf("open %s not found", id), http.StatusNotFound)
+		return nil, false
+	}
+	return job, true
+}
+
+func clampFloat(n, min, max float64) float64 {
+	if n < min {
+		return min
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
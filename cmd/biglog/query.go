@@ -0,0 +1,400 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/tm-LBenson/big-log-viewer/internal/indexer"
+)
+
+// Search queries (?query=1) combine terms with AND, OR, NOT and
+// parentheses; terms next to each other must all match. A term is a word,
+// a "quoted phrase" or a /regex/, matched anywhere in the line. A regex may
+// be followed by i or c to ignore or match case, and case: or nocase: in
+// front of any term does the same; otherwise the case param decides.
+// tone:error, tone:warn, tone:ok and tone:info match lines by the tone the
+// viewer colors them with.
+
+// queryError is a malformed query. Col counts characters from 1.
+type queryError struct {
+	Col int
+	Msg string
+}
+
+func (e *queryError) Error() string {
+	return fmt.Sprintf("invalid query at column %d: %s", e.Col, e.Msg)
+}
+
+var queryTones = map[string]string{
+	"error": "error", "warn": "warn", "warning": "warn",
+	"ok": "ok", "success": "ok", "info": "info",
+}
+
+// queryLine is a line being matched, with what terms derive from it
+// computed once.
+type queryLine struct {
+	text  string
+	lower string
+	tone  string
+	known uint8 // 1: lower set, 2: tone set
+}
+
+func (l *queryLine) lowered() string {
+	if l.known&1 == 0 {
+		l.lower, l.known = strings.ToLower(l.text), l.known|1
+	}
+	return l.lower
+}
+
+func (l *queryLine) toned() string {
+	if l.known&2 == 0 {
+		l.tone, l.known = detectLogTone(l.text, l.text), l.known|2
+	}
+	return l.tone
+}
+
+type queryNode interface {
+	eval(l *queryLine) bool
+}
+
+type (
+	andNode  []queryNode
+	orNode   []queryNode
+	notNode  struct{ queryNode }
+	textTerm struct {
+		needle string
+		fold   bool
+	}
+	regexTerm struct{ re *regexp.Regexp }
+	toneTerm  string
+)
+
+func (n andNode) eval(l *queryLine) bool {
+	for _, c := range n {
+		if !c.eval(l) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n orNode) eval(l *queryLine) bool {
+	for _, c := range n {
+		if c.eval(l) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n notNode) eval(l *queryLine) bool { return !n.queryNode.eval(l) }
+
+func (t textTerm) eval(l *queryLine) bool {
+	if t.fold {
+		return strings.Contains(l.lowered(), t.needle)
+	}
+	return strings.Contains(l.text, t.needle)
+}
+
+func (t regexTerm) eval(l *queryLine) bool { return t.re.MatchString(l.text) }
+
+func (t toneTerm) eval(l *queryLine) bool { return l.toned() == string(t) }
+
+// searchQuery is a parsed query.
+type searchQuery struct {
+	root queryNode
+}
+
+// Match matches a line as stored, markup included.
+func (q *searchQuery) Match(text string) bool {
+	return q.root.eval(&queryLine{text: text})
+}
+
+// MatchRow matches a cleaned byte-mode row whose tone is already known.
+func (q *searchQuery) MatchRow(row textWindowLine) bool {
+	return q.root.eval(&queryLine{text: row.Text, tone: row.Tone, known: 2})
+}
+
+type queryToken struct {
+	kind string // "(", ")", "AND", "OR", "NOT", "term" or "end"
+	col  int
+	node queryNode
+}
+
+// parseQuery parses q. caseSensitive is the default for terms that don't
+// say.
+func parseQuery(q string, caseSensitive bool) (*searchQuery, error) {
+	toks, err := lexQuery(q, caseSensitive)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{toks: toks}
+	if p.peek().kind == "end" {
+		return nil, &queryError{1, "the query is empty"}
+	}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "end" {
+		return nil, &queryError{t.col, fmt.Sprintf("unexpected %q", t.kind)}
+	}
+	return &searchQuery{root}, nil
+}
+
+type queryParser struct {
+	toks []queryToken
+	pos  int
+}
+
+func (p *queryParser) peek() queryToken { return p.toks[p.pos] }
+
+func (p *queryParser) next() queryToken {
+	t := p.toks[p.pos]
+	if t.kind != "end" {
+		p.pos++
+	}
+	return t
+}
+
+func (p *queryParser) or() (queryNode, error) {
+	var terms orNode
+	for {
+		n, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, n)
+		if p.peek().kind != "OR" {
+			break
+		}
+		p.next()
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return terms, nil
+}
+
+func (p *queryParser) and() (queryNode, error) {
+	var terms andNode
+	for {
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, n)
+		switch p.peek().kind {
+		case "AND":
+			p.next()
+			continue
+		case "term", "NOT", "(":
+			continue
+		}
+		break
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return terms, nil
+}
+
+func (p *queryParser) unary() (queryNode, error) {
+	t := p.next()
+	switch t.kind {
+	case "NOT":
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	case "(":
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != ")" {
+			return nil, &queryError{t.col, "this ( is never closed"}
+		}
+		return n, nil
+	case "term":
+		return t.node, nil
+	case "end":
+		return nil, &queryError{t.col, "expected a term at the end of the query"}
+	default:
+		return nil, &queryError{t.col, fmt.Sprintf("expected a term before %q", t.kind)}
+	}
+}
+
+func lexQuery(q string, caseSensitive bool) ([]queryToken, error) {
+	var toks []queryToken
+	col := func(i int) int { return utf8.RuneCountInString(q[:i]) + 1 }
+	i := 0
+	for i < len(q) {
+		r, size := utf8.DecodeRuneInString(q[i:])
+		if unicode.IsSpace(r) {
+			i += size
+			continue
+		}
+		start := i
+		if r == '(' || r == ')' {
+			toks = append(toks, queryToken{kind: string(r), col: col(i)})
+			i++
+			continue
+		}
+
+		// case: and nocase: in front of a term set how it treats case.
+		fold := !caseSensitive
+		switch {
+		case strings.HasPrefix(q[i:], "case:"):
+			fold, i = false, i+len("case:")
+		case strings.HasPrefix(q[i:], "nocase:"):
+			fold, i = true, i+len("nocase:")
+		}
+		if i >= len(q) || (q[i] != '"' && q[i] != '/') {
+			// A word runs up to a space, a paren or a quote.
+			end := i
+			for end < len(q) {
+				c, n := utf8.DecodeRuneInString(q[end:])
+				if unicode.IsSpace(c) || c == '(' || c == ')' || c == '"' {
+					break
+				}
+				end += n
+			}
+			word := q[i:end]
+			switch {
+			case word == "":
+				return nil, &queryError{col(start), "expected a word, \"phrase\" or /regex/ after " + q[start:i]}
+			case start == i && (word == "AND" || word == "OR" || word == "NOT"):
+				toks = append(toks, queryToken{kind: word, col: col(i)})
+			case start == i && strings.HasPrefix(word, "tone:"):
+				tone, ok := queryTones[strings.ToLower(word[len("tone:"):])]
+				if !ok {
+					return nil, &queryError{col(i), fmt.Sprintf("unknown tone %q; use error, warn, ok or info", word[len("tone:"):])}
+				}
+				toks = append(toks, queryToken{kind: "term", col: col(i), node: toneTerm(tone)})
+			default:
+				if fold {
+					word = strings.ToLower(word)
+				}
+				toks = append(toks, queryToken{kind: "term", col: col(start), node: textTerm{word, fold}})
+			}
+			i = end
+			continue
+		}
+
+		// A quoted phrase or a regex, with \ escaping its closing quote.
+		delim := q[i]
+		var body strings.Builder
+		j := i + 1
+		for ; j < len(q) && q[j] != delim; j++ {
+			if q[j] == '\\' && j+1 < len(q) && (q[j+1] == delim || (delim == '"' && q[j+1] == '\\')) {
+				j++
+			}
+			body.WriteByte(q[j])
+		}
+		if j >= len(q) {
+			kind := "phrase"
+			if delim == '/' {
+				kind = "regex"
+			}
+			return nil, &queryError{col(i), "this " + kind + " is never closed"}
+		}
+		j++
+		if delim == '"' {
+			needle := body.String()
+			if fold {
+				needle = strings.ToLower(needle)
+			}
+			toks = append(toks, queryToken{kind: "term", col: col(start), node: textTerm{needle, fold}})
+			i = j
+			continue
+		}
+		for ; j < len(q) && (q[j] == 'i' || q[j] == 'c'); j++ {
+			fold = q[j] == 'i'
+		}
+		if c, _ := utf8.DecodeRuneInString(q[j:]); j < len(q) && !unicode.IsSpace(c) && c != '(' && c != ')' {
+			return nil, &queryError{col(j), fmt.Sprintf("unknown regex flag %q; use i or c", c)}
+		}
+		pattern := body.String()
+		if fold {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, &queryError{col(i), "bad regex: " + strings.TrimPrefix(err.Error(), "error parsing regexp: ")}
+		}
+		toks = append(toks, queryToken{kind: "term", col: col(start), node: regexTerm{re}})
+		i = j
+	}
+	return append(toks, queryToken{kind: "end", col: col(len(q))}), nil
+}
+
+// requestMatcher builds the matcher a search asks for: a query with
+// query=1, otherwise newTextMatcher's substring or regex. matchRow is the
+// same test for byte-mode rows. In line mode match runs on f's lines as
+// stored, or on their cleaned rows as byte mode would see them when
+// cleanMatching says so. f is nil for searches outside an open file.
+func requestMatcher(r *http.Request, f *indexer.File, q string) (match func(string) bool, matchRow func(textWindowLine) bool, err error) {
+	clean := false
+	if f != nil {
+		if clean, err = cleanMatching(r, f); err != nil {
+			return nil, nil, err
+		}
+	}
+	caseSensitive := r.URL.Query().Get("case") == "1"
+	if r.URL.Query().Get("query") == "1" {
+		query, err := parseQuery(q, caseSensitive)
+		if err != nil {
+			return nil, nil, err
+		}
+		match, matchRow = query.Match, query.MatchRow
+	} else {
+		text, err := newTextMatcher(q, r.URL.Query().Get("regex") == "1", caseSensitive)
+		if err != nil {
+			return nil, nil, err
+		}
+		match, matchRow = text, func(row textWindowLine) bool { return text(row.Text) }
+	}
+	if clean {
+		match = matchCleaned(matchRow)
+	}
+	return match, matchRow, nil
+}
+
+// matchCleaned matches a line by the rows its cleaned text splits into.
+func matchCleaned(matchRow func(textWindowLine) bool) func(string) bool {
+	return func(text string) bool {
+		for _, row := range cleanTextRows(text, 0, true) {
+			if matchRow(row) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// cleanMatching reports whether a line-mode search of f should match
+// cleaned text, without markup, instead of the raw line: match=clean or
+// match=raw says, and HTML logs default to clean.
+func cleanMatching(r *http.Request, f *indexer.File) (bool, error) {
+	return cleanMatchingPath(r, f.Path)
+}
+
+// cleanMatchingPath is cleanMatching for the file at p.
+func cleanMatchingPath(r *http.Request, p string) (bool, error) {
+	switch r.URL.Query().Get("match") {
+	case "clean":
+		return true, nil
+	case "raw":
+		return false, nil
+	case "":
+		ext, innerExt, _ := fileExtensions(p)
+		return detectFileFormat(ext, innerExt) == "HTML", nil
+	}
+	return false, fmt.Errorf("match must be raw or clean")
+}
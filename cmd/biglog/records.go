@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/tm-LBenson/big-log-viewer/internal/indexer"
+)
+
+// recordPattern is the record start regex used when a request doesn't
+// give one.
+var recordPattern = indexer.DefaultRecordPattern
+
+type recordsReq struct {
+	Enabled bool   `json:"enabled"`
+	Pattern string `json:"pattern"`
+}
+
+type recordsResp struct {
+	Enabled bool   `json:"enabled"`
+	Pattern string `json:"pattern"`
+	Records int    `json:"records"`
+	Lines   int    `json:"lines"`
+}
+
+// recordsHandler reports record mode for the open file on GET and turns it
+// on or off on POST. The index is built under the read lock so paging
+// continues while a big file is scanned.
+func recordsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		mu.RLock()
+		defer mu.RUnlock()
+		f, err := fileFor(r)
+		if err != nil {
+			fileError(w, err)
+			return
+		}
+		writeJSON(w, recordsStatus(f))
+	case http.MethodPost:
+		var req recordsReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		if !req.Enabled {
+			mu.Lock()
+			defer mu.Unlock()
+			f, err := fileFor(r)
+			if err != nil {
+				fileError(w, err)
+				return
+			}
+			_ = f.SetRecords(nil)
+			writeJSON(w, recordsStatus(f))
+			return
+		}
+		pattern := req.Pattern
+		if pattern == "" {
+			pattern = recordPattern
+		}
+
+		mu.RLock()
+		f, err := fileFor(r)
+		if err != nil {
+			mu.RUnlock()
+			fileError(w, err)
+			return
+		}
+		recs, err := f.ScanRecords(r.Context(), pattern)
+		mu.RUnlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if handleOf(f) == nil {
+			http.Error(w, "the file changed while records were indexed", http.StatusConflict)
+			return
+		}
+		if err := f.SetRecords(recs); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, recordsStatus(f))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func recordsStatus(f *indexer.File) recordsResp {
+	resp := recordsResp{Pattern: recordPattern, Lines: f.Lines}
+	if f.Records != nil {
+		resp.Enabled = true
+		resp.Pattern = f.Records.Pattern
+		resp.Records = f.Records.Len()
+	}
+	return resp
+}
+
+// recordChunk is /api/chunk?records=1: whole records, each with the row it
+// starts at so the viewer can map records back to rows. Truncated lists the
+// records cut at indexer.MaxRecordBytes.
+func recordChunk(f *indexer.File, start, count int) (chunkResp, error) {
+	recs, err := f.SliceRecords(start, count)
+	if err != nil {
+		return chunkResp{}, err
+	}
+	resp := chunkResp{Lines: make([]string, len(recs)), Rows: make([]int, len(recs))}
+	for i, rec := range recs {
+		resp.Lines[i] = rec.Text
+		resp.Rows[i] = rec.Row
+		if rec.Truncated {
+			resp.Truncated = append(resp.Truncated, start+i)
+		}
+	}
+	return resp, nil
+}
+
+// searchRecords matches whole records and returns record numbers.
+func searchRecords(f *indexer.File, matcher func(string) bool, limit int) ([]int, int, error) {
+	if f.Records == nil {
+		return nil, 0, indexer.ErrNoRecords
+	}
+	matches := make([]int, 0, limit)
+	total := 0
+	err := f.EachRecord(0, f.Records.Len(), func(i int, rec indexer.Record) error {
+		if matcher(rec.Text) {
+			total++
+			if len(matches) < limit {
+				matches = append(matches, i)
+			}
+		}
+		return nil
+	})
+	return matches, total, err
+}
+
+func recordsError(w http.ResponseWriter, err error) {
+	if errors.Is(err, indexer.ErrNoRecords) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
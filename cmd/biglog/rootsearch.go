@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tm-LBenson/big-log-viewer/internal/indexer"
+)
+
+// rootSearchWorkers is how many files a directory search reads at once.
+var rootSearchWorkers = 4
+
+// rootSearchMatch is one matching line. Line is the row it starts on, as
+// the viewer counts them: a line longer than indexer.MaxIndexedLineBytes
+// takes a row per piece but is matched whole, as /api/search does. Offset
+// is where the line starts, in the decompressed text of a .gz.
+type rootSearchMatch struct {
+	Line   int    `json:"line"`
+	Offset int64  `json:"offset"`
+	Text   string `json:"text"`
+	Tone   string `json:"tone,omitempty"`
+}
+
+// rootSearchFile is sent for every file with a match, or that could not be
+// read. Total counts every match; Matches holds the first limit.
+type rootSearchFile struct {
+	Path       string            `json:"path"`
+	Size       int64             `json:"size"`
+	ModTime    int64             `json:"modTime"`
+	Compressed bool              `json:"compressed,omitempty"`
+	Matches    []rootSearchMatch `json:"matches"`
+	Total      int               `json:"total"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// rootSearchStatus is sent as progress and as the closing done event. Bytes
+// counts what was read from disk across the Files to search.
+type rootSearchStatus struct {
+	Files        int   `json:"files"`
+	Searched     int   `json:"searched"`
+	Bytes        int64 `json:"bytes"`
+	TotalBytes   int64 `json:"totalBytes"`
+	MatchedFiles int   `json:"matchedFiles"`
+	Total        int   `json:"total"`
+}
+
+type rootSearchTarget struct {
+	abs  string
+	info os.FileInfo
+}
+
+// searchRootHandler is /api/search/root: it searches every file /api/list
+// would show, .gz files included, and streams a file event per file with
+// matches, then a done event. q, regex, case, query and match work as for
+// /api/search, so HTML logs match their cleaned text unless match=raw, and
+// limit caps the matches sent per file. glob narrows the
+// files by their path under the root, or by name when it has no slash;
+// since and until by modification time, given as Unix milliseconds or in
+// any timestamp format /api/seek-time takes.
+func searchRootHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		http.Error(w, "q param required", http.StatusBadRequest)
+		return
+	}
+	matcher, matchRow, err := requestMatcher(r, nil, q)
+	if err == nil {
+		_, err = cleanMatchingPath(r, "")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cleanMatcher := matchCleaned(matchRow)
+	limit := atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = 100
+	}
+	glob := r.URL.Query().Get("glob")
+	if _, err := path.Match(glob, ""); err != nil {
+		http.Error(w, "bad glob: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	since, ok := modTimeParam(r.URL.Query().Get("since"))
+	until, ok2 := modTimeParam(r.URL.Query().Get("until"))
+	if !ok || !ok2 {
+		http.Error(w, "since and until must be Unix milliseconds or look like 2025-10-06T15:29:20Z", http.StatusBadRequest)
+		return
+	}
+
+	mu.RLock()
+	root := rootDir
+	mu.RUnlock()
+	targets := rootSearchTargets(root, glob, since, until)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	status := rootSearchStatus{Files: len(targets)}
+	for _, t := range targets {
+		status.TotalBytes += t.info.Size()
+	}
+	writeEvent(w, "progress", status)
+	flusher.Flush()
+
+	ctx := r.Context()
+	var read atomic.Int64
+	jobs := make(chan rootSearchTarget)
+	results := make(chan rootSearchFile)
+	var wg sync.WaitGroup
+	for i := 0; i < min(max(rootSearchWorkers, 1), max(len(targets), 1)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range jobs {
+				match := matcher
+				clean, _ := cleanMatchingPath(r, t.abs)
+				if clean {
+					match = cleanMatcher
+				}
+				results <- searchRootFile(ctx, root, t, match, clean, limit, &read)
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, t := range targets {
+			select {
+			case jobs <- t:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	ticker := time.NewTicker(searchProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case res, open := <-results:
+			if !open {
+				if ctx.Err() != nil {
+					return
+				}
+				status.Bytes = read.Load()
+				writeEvent(w, "done", status)
+				flusher.Flush()
+				return
+			}
+			if ctx.Err() != nil {
+				continue
+			}
+			status.Searched++
+			if res.Total > 0 {
+				status.MatchedFiles++
+				status.Total += res.Total
+			}
+			if res.Total > 0 || res.Error != "" {
+				writeEvent(w, "file", res)
+				flusher.Flush()
+			}
+		case <-ticker.C:
+			status.Bytes = read.Load()
+			writeEvent(w, "progress", status)
+			flusher.Flush()
+		}
+	}
+}
+
+// modTimeParam parses since and until; an empty value gives the zero time.
+func modTimeParam(v string) (time.Time, bool) {
+	if v == "" {
+		return time.Time{}, true
+	}
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.UnixMilli(ms), true
+	}
+	return indexer.ParseTimestamp(v)
+}
+
+// rootSearchTargets lists the files under root a directory search reads.
+func rootSearchTargets(root, glob string, since, until time.Time) []rootSearchTarget {
+	extMu.RLock()
+	curExtSet := cloneExtSet()
+	allowAllText := hasWildcard(curExtSet)
+	extMu.RUnlock()
+
+	var out []rootSearchTarget
+	filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if glob != "" {
+			rel, _ := filepath.Rel(root, p)
+			name := filepath.ToSlash(rel)
+			if !strings.Contains(glob, "/") {
+				name = d.Name()
+			}
+			if ok, _ := path.Match(glob, name); !ok {
+				return nil
+			}
+		}
+		if !shouldIncludeFile(p, curExtSet, allowAllText) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if !since.IsZero() && info.ModTime().Before(since) || !until.IsZero() && info.ModTime().After(until) {
+			return nil
+		}
+		out = append(out, rootSearchTarget{p, info})
+		return nil
+	})
+	return out
+}
+
+// countingReader adds what it reads to n.
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// searchRootFile reads one file for searchRootHandler. clean says matcher
+// takes cleaned text, which the match's snippet then shows too.
+func searchRootFile(ctx context.Context, root string, t rootSearchTarget, matcher func(string) bool, clean bool, limit int, read *atomic.Int64) rootSearchFile {
+	rel, _ := filepath.Rel(root, t.abs)
+	res := rootSearchFile{
+		Path:       filepath.ToSlash(rel),
+		Size:       t.info.Size(),
+		ModTime:    t.info.ModTime().UnixMilli(),
+		Compressed: indexer.IsGzipPath(t.abs),
+		Matches:    []rootSearchMatch{},
+	}
+	file, err := os.Open(t.abs)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	defer file.Close()
+	var rd io.Reader = countingReader{file, read}
+	if res.Compressed {
+		gz, err := gzip.NewReader(rd)
+		if err != nil {
+			res.Error = err.Error()
+			return res
+		}
+		defer gz.Close()
+		rd = gz
+	}
+
+	// Read the file as the viewer would open it, with any encoding or line
+	// ending set for it.
+	opts, _ := openOptionsFor(t.abs, nil)
+	lr := indexer.NewStreamLineReader(rd, opts, 1<<20)
+	enc := lr.Encoding()
+	row, first := 0, 0
+	var offset, lineOffset, rowBytes int64
+	raw := make([]byte, 0, 16<<10)
+	emit := func() {
+		text := strings.TrimRight(indexer.DecodeRow(enc, raw, lineOffset), "\r\n")
+		if matcher(text) {
+			res.Total++
+			if len(res.Matches) < limit {
+				line := contextText(text, !clean)
+				res.Matches = append(res.Matches, rootSearchMatch{first, lineOffset, previewText(line.Text), line.Tone})
+			}
+		}
+		raw = raw[:0]
+	}
+	for {
+		part, err := lr.ReadSlice()
+		for len(part) > 0 {
+			if rowBytes == indexer.MaxIndexedLineBytes {
+				// The index starts a continuation row here. Past
+				// searchJoinMaxBytes the rest of the line is matched
+				// apart, as splitLineJoiner does.
+				row++
+				rowBytes = 0
+				if len(raw) >= searchJoinMaxBytes {
+					emit()
+					first, lineOffset = row, offset
+				}
+			}
+			take := min(int64(len(part)), indexer.MaxIndexedLineBytes-rowBytes)
+			raw = append(raw, part[:take]...)
+			offset += take
+			rowBytes += take
+			part = part[take:]
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if len(raw) > 0 {
+			emit()
+		}
+		if err == io.EOF {
+			return res
+		}
+		if err != nil {
+			res.Error = err.Error()
+			return res
+		}
+		row++
+		first, lineOffset, rowBytes = row, offset, 0
+		if row%4096 == 0 && ctx.Err() != nil {
+			res.Error = ctx.Err().Error()
+			return res
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/tm-LBenson/big-log-viewer/internal/indexer"
+)
+
+// searchContextMax caps before and after.
+const searchContextMax = 100
+
+// searchContext is a run of lines around one or more matches, like a block
+// of grep -C output. Windows that overlap or touch are merged into one.
+type searchContext struct {
+	Lines []searchContextLine `json:"lines"`
+}
+
+// searchContextLine is a row, or a record with records=1. Byte-mode files
+// have no rows yet, so Line is -1 and Offset says where the text is.
+type searchContextLine struct {
+	Line   int    `json:"line"`
+	Offset int64  `json:"offset,omitempty"`
+	Text   string `json:"text"`
+	Tone   string `json:"tone,omitempty"`
+	Match  bool   `json:"match,omitempty"`
+}
+
+// contextParams reads before and after, and text, which picks raw lines or
+// cleaned text; without it the context is cleaned wherever the search
+// matched cleaned text, as it does by byte offset and for HTML logs.
+func contextParams(r *http.Request, f *indexer.File) (before, after int, raw bool) {
+	before = min(max(atoi(r.URL.Query().Get("before")), 0), searchContextMax)
+	after = min(max(atoi(r.URL.Query().Get("after")), 0), searchContextMax)
+	switch r.URL.Query().Get("text") {
+	case "raw":
+		raw = true
+	case "clean":
+		raw = false
+	default:
+		clean, _ := cleanMatching(r, f)
+		raw = !byteSearch(r, f) && !clean
+	}
+	return before, after, raw
+}
+
+// contextText is text as a context line shows it.
+func contextText(text string, raw bool) searchContextLine {
+	if raw {
+		text = strings.TrimRight(text, "\r\n")
+		return searchContextLine{Text: text, Tone: detectLogTone(text, text)}
+	}
+	var parts []string
+	for _, part := range strings.Split(cleanLogText(text), "\n") {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	cleaned := strings.Join(parts, " ")
+	return searchContextLine{Text: cleaned, Tone: detectLogTone(text, cleaned)}
+}
+
+// indexContext builds the context around matches, which are rows or
+// records out of n. read calls emit for each of start..end-1.
+func indexContext(matches []int, n, before, after int, raw bool, read func(start, end int, emit func(i int, text string)) error) ([]searchContext, error) {
+	type span struct{ start, end int }
+	var spans []span
+	for _, m := range matches {
+		s := span{max(m-before, 0), min(m+after+1, n)}
+		if last := len(spans) - 1; last >= 0 && s.start <= spans[last].end {
+			spans[last].end = max(spans[last].end, s.end)
+			continue
+		}
+		spans = append(spans, s)
+	}
+	isMatch := make(map[int]bool, len(matches))
+	for _, m := range matches {
+		isMatch[m] = true
+	}
+	out := make([]searchContext, 0, len(spans))
+	for _, s := range spans {
+		block := searchContext{Lines: make([]searchContextLine, 0, s.end-s.start)}
+		err := read(s.start, s.end, func(i int, text string) {
+			line := contextText(text, raw)
+			line.Line, line.Match = i, isMatch[i]
+			block.Lines = append(block.Lines, line)
+		})
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, block)
+	}
+	return out, nil
+}
+
+func rowContext(f *indexer.File, matches []int, before, after int, raw bool) ([]searchContext, error) {
+	return indexContext(matches, f.Lines, before, after, raw, func(start, end int, emit func(int, string)) error {
+		rows, err := f.SliceRows(start, end-start)
+		for i, row := range rows {
+			emit(start+i, row.Text)
+		}
+		return err
+	})
+}
+
+func recordContext(f *indexer.File, matches []int, before, after int, raw bool) ([]searchContext, error) {
+	return indexContext(matches, f.Records.Len(), before, after, raw, func(start, end int, emit func(int, string)) error {
+		return f.EachRecord(start, end, func(i int, rec indexer.Record) error {
+			emit(i, rec.Text)
+			return nil
+		})
+	})
+}
+
+// contextRow is a byte-mode row with its place in the file: rows of a
+// transcoded line share its offset, so n counts them apart.
+type contextRow struct {
+	textWindowLine
+	n int
+}
+
+type contextKey struct {
+	offset int64
+	n      int
+}
+
+func (r contextRow) key() contextKey { return contextKey{r.Offset, r.n} }
+
+func (k contextKey) after(o contextKey) bool {
+	return k.offset > o.offset || k.offset == o.offset && k.n > o.n
+}
+
+// byteContext builds the context around byte-mode matches, counting cleaned
+// rows, or whole lines with raw. It reads at most hugeMaxWindowBytes either
+// way from each match.
+func byteContext(f *indexer.File, items []hugeSearchItem, before, after int, raw bool) ([]searchContext, error) {
+	type block struct {
+		rows []contextRow
+		next *contextKey
+	}
+	var blocks []block
+	matchKeys := map[contextKey]bool{}
+	for _, item := range items {
+		rows, idx, err := contextRowsAround(f, item, before, after, raw)
+		if err != nil {
+			return nil, err
+		}
+		matchKeys[rows[idx].key()] = true
+		start, end := max(idx-before, 0), min(idx+after+1, len(rows))
+		var next *contextKey
+		if end < len(rows) {
+			k := rows[end].key()
+			next = &k
+		}
+		window := rows[start:end]
+		if n := len(blocks); n > 0 {
+			last := &blocks[n-1]
+			lastKey := last.rows[len(last.rows)-1].key()
+			if first := window[0].key(); !first.after(lastKey) || last.next != nil && first == *last.next {
+				for _, row := range window {
+					if row.key().after(lastKey) {
+						last.rows = append(last.rows, row)
+					}
+				}
+				if last.rows[len(last.rows)-1].key() == window[len(window)-1].key() {
+					last.next = next
+				}
+				continue
+			}
+		}
+		blocks = append(blocks, block{window, next})
+	}
+
+	out := make([]searchContext, 0, len(blocks))
+	for _, b := range blocks {
+		lines := make([]searchContextLine, 0, len(b.rows))
+		for _, row := range b.rows {
+			lines = append(lines, searchContextLine{
+				Line:   -1,
+				Offset: row.Offset,
+				Text:   row.Text,
+				Tone:   row.Tone,
+				Match:  matchKeys[row.key()],
+			})
+		}
+		out = append(out, searchContext{lines})
+	}
+	return out, nil
+}
+
+// contextRowsAround returns the rows near item, with at least before rows
+// ahead of it and after rows behind it where the file has them, and the
+// index of item's own row.
+func contextRowsAround(f *indexer.File, item hugeSearchItem, before, after int, raw bool) ([]contextRow, int, error) {
+	lineStart := lineStartAtOrBefore(f, item.Offset)
+	var rows []contextRow
+	idx := -1
+	for pos := lineStart; pos < f.Size && pos-lineStart < hugeMaxWindowBytes; {
+		text, next, err := readContextLine(f, pos)
+		if err != nil {
+			return nil, 0, err
+		}
+		lineRows := contextLineRows(f, text, pos, raw)
+		if idx < 0 {
+			for k, row := range lineRows {
+				if raw || row.Offset == item.Offset && row.Text == item.Text {
+					idx = len(rows) + k
+					break
+				}
+			}
+			if idx < 0 {
+				// The match is past the part of a huge line read here.
+				return []contextRow{{textWindowLine: textWindowLine{Offset: item.Offset, Text: item.Text, Tone: item.Tone}}}, 0, nil
+			}
+		}
+		rows = append(rows, lineRows...)
+		pos = next
+		// One row more than needed tells whether the next window touches.
+		if len(rows)-idx > after+1 {
+			break
+		}
+	}
+
+	for pos := lineStart; idx < before && pos > 0 && lineStart-pos < hugeMaxWindowBytes; {
+		prev := lineStartAtOrBefore(f, pos-1)
+		if prev >= pos {
+			break
+		}
+		text, _, err := readContextLine(f, prev)
+		if err != nil {
+			return nil, 0, err
+		}
+		lineRows := contextLineRows(f, text, prev, raw)
+		rows = append(lineRows, rows...)
+		idx += len(lineRows)
+		pos = prev
+	}
+	return rows, idx, nil
+}
+
+// contextLineRows turns a line into the rows context shows for it.
+func contextLineRows(f *indexer.File, line []byte, offset int64, raw bool) []contextRow {
+	if raw {
+		text := strings.TrimRight(indexer.DecodeRow(f.Encoding, line, offset), "\r\n")
+		return []contextRow{{textWindowLine: textWindowLine{Offset: offset, Text: text, Tone: detectLogTone(text, text)}}}
+	}
+	cleaned := cleanLogRows(f, line, offset)
+	rows := make([]contextRow, len(cleaned))
+	for i, row := range cleaned {
+		rows[i].textWindowLine = row
+		if i > 0 && cleaned[i-1].Offset == row.Offset {
+			rows[i].n = rows[i-1].n + 1
+		}
+	}
+	return rows
+}
+
+// readContextLine reads the line starting at offset, up to
+// hugeMaxLineBytes of it, and returns where the next line starts.
+func readContextLine(f *indexer.File, offset int64) ([]byte, int64, error) {
+	lr := f.NewLineReader(io.NewSectionReader(f, offset, f.Size-offset), 64<<10)
+	var line []byte
+	next := offset
+	for {
+		part, err := lr.ReadSlice()
+		next += int64(len(part))
+		if int64(len(line)) < hugeMaxLineBytes {
+			line = append(line, part[:min(len(part), int(hugeMaxLineBytes)-len(line))]...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return nil, next, err
+		}
+		return line, next, nil
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tm-LBenson/big-log-viewer/internal/indexer"
+)
+
+// searchWorkers is how many goroutines a search fans out to.
+var searchWorkers = runtime.GOMAXPROCS(0)
+
+// A search is cut into ranges of this many rows or bytes, each scanned by
+// one worker.
+var (
+	searchRangeRows        = 64 * indexer.Group
+	searchRangeBytes int64 = 16 << 20
+)
+
+// parallelRanges runs task for ranges 0..n-1 on searchWorkers goroutines
+// and passes the results to merge in range order. Once merge returns false
+// no more ranges are started. It returns after every task has finished, so
+// tasks may rely on locks the caller holds.
+func parallelRanges[T any](n int, task func(i int) T, merge func(i int, res T) bool) {
+	results := make([]chan T, n)
+	for i := range results {
+		results[i] = make(chan T, 1)
+	}
+	var next atomic.Int64
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for w := 0; w < min(max(searchWorkers, 1), n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= n || stop.Load() {
+					return
+				}
+				results[i] <- task(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if !merge(i, <-results[i]) {
+			stop.Store(true)
+			break
+		}
+	}
+	wg.Wait()
+}
+
+// errRangeEnd stops a range once it has finished the line it ends in.
+var errRangeEnd = errors.New("end of range")
+
+type lineHits struct {
+	matches []int
+	total   int
+	err     error
+}
+
+// searchLineRanges is the line-mode search: it returns the first row of the
+// first limit matching lines and how many lines match in all.
+func searchLineRanges(f *indexer.File, matcher func(string) bool, limit int) ([]int, int, error) {
+	lines := f.Lines
+	// The gzip reader serves one cursor at a time, so a .gz is read in a
+	// single range.
+	rangeRows := searchRangeRows
+	if f.Compressed {
+		rangeRows = max(lines, 1)
+	}
+	task := func(i int) lineHits {
+		start := i * rangeRows
+		end := min(start+rangeRows, lines)
+		var hits lineHits
+		emit := func(row int, text string) {
+			if matcher(text) {
+				hits.total++
+				if len(hits.matches) < limit {
+					hits.matches = append(hits.matches, row)
+				}
+			}
+		}
+		// Rows continuing a line from the range before belong to that
+		// range, which reads past its end to finish its last line.
+		joiner := splitLineJoiner{row: -1}
+		skipping := start > 0
+		err := f.EachRow(start, lines, func(i int, row indexer.Row) error {
+			if skipping && row.Continued {
+				return nil
+			}
+			skipping = false
+			if i >= end && !row.Continued {
+				return errRangeEnd
+			}
+			joiner.add(i, []indexer.Row{row}, emit)
+			return nil
+		})
+		if err != nil && err != errRangeEnd {
+			hits.err = err
+			return hits
+		}
+		joiner.flush(emit)
+		return hits
+	}
+
+	matches := make([]int, 0, limit)
+	total := 0
+	var err error
+	n := (lines + rangeRows - 1) / rangeRows
+	parallelRanges(n, task, func(_ int, hits lineHits) bool {
+		if hits.err != nil {
+			err = hits.err
+			return false
+		}
+		total += hits.total
+		matches = append(matches, hits.matches[:min(len(hits.matches), limit-len(matches))]...)
+		return true
+	})
+	return matches, total, err
+}
+
+type byteHits struct {
+	rows []textWindowLine
+	next int64
+	err  error
+}
+
+// scanByteRanges is scanCleanRows over offset..offset+maxBytes with a keep
+// func, run in line-aligned ranges at once. It stops where the sequential
+// scan would once maxRows rows are kept.
+func scanByteRanges(f *indexer.File, offset, maxBytes int64, maxRows int, keep func(textWindowLine) bool) ([]textWindowLine, int64, bool, error) {
+	end := min(offset+maxBytes, f.Size)
+	n := int(max((end-offset+searchRangeBytes-1)/searchRangeBytes, 1))
+	if f.Compressed {
+		n = 1
+	}
+	starts := make([]int64, n+1)
+	starts[0], starts[n] = offset, end
+	for i := 1; i < n; i++ {
+		starts[i] = max(lineStartAtOrBefore(f, offset+int64(i)*searchRangeBytes), starts[i-1])
+	}
+	task := func(i int) byteHits {
+		from, to := starts[i], starts[i+1]
+		if to <= from {
+			return byteHits{next: from}
+		}
+		rows, next, _, err := scanCleanRows(f, from, to-from, maxRows, keep, false)
+		if i < n-1 {
+			// Only a line longer than hugeMaxLineBytes runs past to.
+			kept := rows[:0]
+			for _, row := range rows {
+				if row.Offset < to {
+					kept = append(kept, row)
+				}
+			}
+			rows, next = kept, min(next, to)
+		}
+		return byteHits{rows, next, err}
+	}
+
+	rows := make([]textWindowLine, 0, maxRows)
+	next := offset
+	var err error
+	parallelRanges(n, task, func(i int, hits byteHits) bool {
+		if hits.err != nil {
+			err = hits.err
+			return false
+		}
+		if need := maxRows - len(rows); len(hits.rows) >= need {
+			// Rescan just this range to find where the limit is reached.
+			var more []textWindowLine
+			more, next, _, err = scanCleanRows(f, starts[i], starts[i+1]-starts[i], need, keep, false)
+			rows = append(rows, more...)
+			return false
+		}
+		rows = append(rows, hits.rows...)
+		next = hits.next
+		return true
+	})
+	if err != nil {
+		return nil, next, false, err
+	}
+	return rows, next, next < f.Size, nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tm-LBenson/big-log-viewer/internal/indexer"
+)
+
+// The stream scans this much per hold of the read lock, so opens and
+// follow updates aren't held up behind a search of a huge file.
+const (
+	searchStreamGroups        = 64
+	searchStreamRecords       = 1024
+	searchStreamBytes   int64 = 16 << 20
+)
+
+const searchProgressInterval = 250 * time.Millisecond
+const searchPreviewBytes = 500
+
+var errSearchFileClosed = errors.New("the file was closed during the search")
+
+// searchMatchEvent is one match. Line is the row, or the record with
+// records=1; byte-mode files have no rows yet, so Line is -1 and Offset says
+// where the match is.
+type searchMatchEvent struct {
+	Line   int    `json:"line"`
+	Offset int64  `json:"offset,omitempty"`
+	Text   string `json:"text"`
+	Tone   string `json:"tone,omitempty"`
+}
+
+// searchStreamStatus is sent as progress while the search runs and as the
+// closing done event. Scanned and Size count Unit, which is "lines",
+// "records" or "bytes". Total counts every match; only the first limit are
+// sent.
+type searchStreamStatus struct {
+	Unit    string  `json:"unit"`
+	Scanned int64   `json:"scanned"`
+	Size    int64   `json:"size"`
+	Percent float64 `json:"percent"`
+	Total   int     `json:"total"`
+	Sent    int     `json:"sent"`
+}
+
+type searchStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	limit   int
+	status  searchStreamStatus
+	pending []searchMatchEvent
+	last    time.Time
+}
+
+func (s *searchStream) match(ev searchMatchEvent) {
+	s.status.Total++
+	if s.status.Sent+len(s.pending) < s.limit {
+		ev.Text = previewText(ev.Text)
+		s.pending = append(s.pending, ev)
+	}
+}
+
+// send writes the matches found since the last call, and progress if it is
+// time for it. It runs without mu held so a slow client can't stall others.
+func (s *searchStream) send(scanned int64) {
+	for _, ev := range s.pending {
+		writeEvent(s.w, "match", ev)
+	}
+	s.status.Sent += len(s.pending)
+	s.pending = s.pending[:0]
+	s.status.Scanned = scanned
+	if s.status.Size > 0 {
+		s.status.Percent = clampFloat(float64(scanned)*100/float64(s.status.Size), 0, 100)
+	}
+	if time.Since(s.last) >= searchProgressInterval {
+		writeEvent(s.w, "progress", s.status)
+		s.last = time.Now()
+	}
+	s.flusher.Flush()
+}
+
+// searchStreamHandler is /api/search/stream: the same search as /api/search,
+// sent as server-sent events. Matches arrive as match events while the file
+// is scanned, with progress events in between, and a done event carries
+// the final total. Closing the connection stops the scan.
+func searchStreamHandler(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	f, err := fileFor(r)
+	if err != nil {
+		mu.RUnlock()
+		fileError(w, err)
+		return
+	}
+	if !checkFile(w, r, f) {
+		mu.RUnlock()
+		return
+	}
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		mu.RUnlock()
+		http.Error(w, "q param required", http.StatusBadRequest)
+		return
+	}
+	limit := atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = 500
+	}
+	matcher, keep, err := requestMatcher(r, f, q)
+	if err != nil {
+		mu.RUnlock()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	status := searchStreamStatus{Unit: "lines", Size: int64(f.Lines)}
+	records := r.URL.Query().Get("records") == "1"
+	switch {
+	case records && f.Records == nil:
+		mu.RUnlock()
+		recordsError(w, indexer.ErrNoRecords)
+		return
+	case records:
+		status = searchStreamStatus{Unit: "records", Size: int64(f.Records.Len())}
+	case f.Mode == indexer.ModeByte:
+		status = searchStreamStatus{Unit: "bytes", Size: f.Size}
+	}
+	mu.RUnlock()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	s := &searchStream{w: w, flusher: flusher, limit: limit, status: status}
+	lines := splitLineJoiner{row: -1}
+	emitLine := func(row int, text string) {
+		if matcher(text) {
+			s.match(searchMatchEvent{Line: row, Text: text, Tone: detectLogTone(text, text)})
+		}
+	}
+
+	// step scans from pos on, under the read lock, and returns where it
+	// stopped.
+	var step func(pos int64) (int64, error)
+	switch status.Unit {
+	case "records":
+		step = func(pos int64) (int64, error) {
+			end := min(pos+searchStreamRecords, status.Size)
+			err := f.EachRecord(int(pos), int(end), func(i int, rec indexer.Record) error {
+				if matcher(rec.Text) {
+					s.match(searchMatchEvent{Line: i, Text: rec.Text})
+				}
+				return nil
+			})
+			return end, err
+		}
+	case "bytes":
+		step = func(pos int64) (int64, error) {
+			rows, next, _, err := scanCleanRows(f, pos, searchStreamBytes, math.MaxInt, keep, false)
+			for _, row := range rows {
+				s.match(searchMatchEvent{Line: -1, Offset: row.Offset, Text: row.Text, Tone: row.Tone})
+			}
+			if next <= pos {
+				next = min(pos+searchStreamBytes, status.Size)
+			}
+			return next, err
+		}
+	default:
+		step = func(pos int64) (int64, error) {
+			end := min(pos+searchStreamGroups*indexer.Group, status.Size)
+			err := f.EachRow(int(pos), int(end), func(i int, row indexer.Row) error {
+				lines.add(i, []indexer.Row{row}, emitLine)
+				return nil
+			})
+			if err != nil {
+				return pos, err
+			}
+			return end, nil
+		}
+	}
+
+	for pos := int64(0); pos < status.Size; {
+		if r.Context().Err() != nil {
+			return
+		}
+		mu.RLock()
+		if handleOf(f) == nil {
+			err = errSearchFileClosed
+		} else {
+			pos, err = step(pos)
+		}
+		mu.RUnlock()
+		if err != nil {
+			writeEvent(w, "error", map[string]string{"error": err.Error()})
+			flusher.Flush()
+			return
+		}
+		s.send(pos)
+	}
+	lines.flush(emitLine)
+	s.send(status.Size)
+	s.status.Percent = 100
+	writeEvent(w, "done", s.status)
+	flusher.Flush()
+}
+
+// previewText cuts a match down to what a result list shows.
+func previewText(s string) string {
+	if len(s) <= searchPreviewBytes {
+		return s
+	}
+	cut := searchPreviewBytes
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut]
+}
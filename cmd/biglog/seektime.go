@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/tm-LBenson/big-log-viewer/internal/indexer"
+)
+
+type seekTimeResp struct {
+	Found  bool   `json:"found"`
+	Line   int    `json:"line"`
+	Offset int64  `json:"offset"`
+	Time   string `json:"time,omitempty"`
+	Mode   string `json:"mode"`
+	Record *int   `json:"record,omitempty"`
+}
+
+// seekTime finds the first entry at or after ?time=, given in any format
+// the indexer recognizes in logs. Line is a row in either mode; Found is
+// false, with Line at the end of the file, when every entry is earlier.
+func seekTime(w http.ResponseWriter, r *http.Request) {
+	t, ok := indexer.ParseTimestamp(r.URL.Query().Get("time"))
+	if !ok {
+		http.Error(w, "time param must look like 2025/10/06 15:29:20 or 2025-10-06T15:29:20Z", http.StatusBadRequest)
+		return
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	f, err := fileFor(r)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	if !checkFile(w, r, f) {
+		return
+	}
+	off, ts, found, err := f.SeekTime(t)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := seekTimeResp{Found: found, Line: f.Lines, Offset: f.Size, Mode: f.Mode}
+	if found {
+		resp.Offset = off
+		resp.Time = ts.Format(time.RFC3339Nano)
+		if resp.Line, err = f.RowAt(off); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if f.Records != nil {
+		rec := f.Records.Find(resp.Line)
+		if !found {
+			rec = f.Records.Len()
+		}
+		resp.Record = &rec
+	}
+	writeJSON(w, resp)
+}
@@ -305,7 +305,7 @@ function formatToneSummary(counts) {
     .join(" ");
 }
 
-export default function HugeLogViewer({ path, fileSize, fileInfo }) {
+export default function HugeLogViewer({ path, handle, fileSize, fileInfo }) {
   const [windowData, setWindowData] = useState(null);
   const [offset, setOffset] = useState(0);
   const [loading, setLoading] = useState(false);
@@ -455,6 +455,7 @@ export default function HugeLogViewer({ path, fileSize, fileInfo }) {
         align: align ? "1" : "0",
       });
       if (tail) params.set("tail", "1");
+      if (handle) params.set("handle", handle);
       const response = await fetch(`/api/window?${params.toString()}`, {
         signal: ctrl.signal,
       });
@@ -471,7 +472,7 @@ export default function HugeLogViewer({ path, fileSize, fileInfo }) {
     } finally {
       if (!ctrl.signal.aborted) setLoading(false);
     }
-  }, []);
+  }, [handle]);
 
   useEffect(() => {
     setWindowData(null);
@@ -567,6 +568,7 @@ export default function HugeLogViewer({ path, fileSize, fileInfo }) {
         });
         if (regex) params.set("regex", "1");
         if (caseSensitive) params.set("case", "1");
+        if (handle) params.set("handle", handle);
         const response = await fetch(`/api/search?${params.toString()}`, {
           signal: ctrl.signal,
         });
@@ -596,7 +598,7 @@ export default function HugeLogViewer({ path, fileSize, fileInfo }) {
         if (!ctrl.signal.aborted) setSearching(false);
       }
     },
-    [caseSensitive, offset, query, regex, selectSearchItem, size],
+    [caseSensitive, handle, offset, query, regex, selectSearchItem, size],
   );
 
   const selectSavedSearch = useCallback(
@@ -645,6 +647,7 @@ export default function HugeLogViewer({ path, fileSize, fileInfo }) {
         });
         if (regex) params.set("regex", "1");
         if (caseSensitive) params.set("case", "1");
+        if (handle) params.set("handle", handle);
         const response = await fetch(`/api/search?${params.toString()}`, {
           signal: ctrl.signal,
         });
@@ -707,7 +710,7 @@ export default function HugeLogViewer({ path, fileSize, fileInfo }) {
     } finally {
       if (!ctrl.signal.aborted) setSearching(false);
     }
-  }, [caseSensitive, query, regex, selectSearchItem, size]);
+  }, [caseSensitive, handle, query, regex, selectSearchItem, size]);
 
   const cancelSearch = useCallback(() => {
     searchCtrl.current?.abort();
@@ -943,6 +946,7 @@ export default function HugeLogViewer({ path, fileSize, fileInfo }) {
         offset: String(windowData.offset || 0),
         limit: String(windowData.limit || WINDOW_BYTES),
       });
+      if (handle) params.set("handle", handle);
       const response = await fetch(`/api/raw-window?${params.toString()}`);
       if (!response.ok) throw new Error(await response.text());
       const text = await response.text();
@@ -951,7 +955,7 @@ export default function HugeLogViewer({ path, fileSize, fileInfo }) {
     } catch {
       showCopyStatus("Copy failed");
     }
-  }, [displayText, showCopyStatus, windowData, writeClipboard]);
+  }, [displayText, handle, showCopyStatus, windowData, writeClipboard]);
 
   const downloadRenderedSection = useCallback(() => {
     if (!displayedLines.length || !windowData) return;
@@ -972,6 +976,7 @@ export default function HugeLogViewer({ path, fileSize, fileInfo }) {
         offset: String(windowData.offset || 0),
         limit: String(windowData.limit || WINDOW_BYTES),
       });
+      if (handle) params.set("handle", handle);
       const response = await fetch(`/api/raw-window?${params.toString()}`);
       if (!response.ok) throw new Error(await response.text());
       const text = await response.text();
@@ -982,7 +987,7 @@ export default function HugeLogViewer({ path, fileSize, fileInfo }) {
     } catch {
       showCopyStatus("Save failed");
     }
-  }, [displayText, sectionStem, showCopyStatus, windowData]);
+  }, [displayText, handle, sectionStem, showCopyStatus, windowData]);
 
   const matchLabel =
     searchIndex >= 0
@@ -33,6 +33,7 @@ export default function LogViewer({ path }) {
     return (
       <HugeLogViewer
         path={path}
+        handle={lines.handle}
         fileSize={lines.fileSize}
         fileInfo={fileInfo}
       />
@@ -46,6 +47,7 @@ export default function LogViewer({ path }) {
       getLine={lines.getLine}
       count={lines.count}
       fileMode={lines.fileMode}
+      handle={lines.handle}
     >
       <Viewer
         virt={virt}
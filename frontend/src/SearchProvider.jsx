@@ -53,6 +53,7 @@ export default function SearchProvider({
   getLine,
   count,
   fileMode = "line",
+  handle = "",
   children,
 }) {
   const s = useSettings().get();
@@ -65,6 +66,7 @@ export default function SearchProvider({
   const streamMode = fileMode === "byte";
   const pageSize = streamMode ? BYTE_PAGE : PAGE;
   const unitLabel = streamMode ? "chunk" : "line";
+  const handleParam = handle ? `&handle=${encodeURIComponent(handle)}` : "";
 
   const colors = {
     hover: s.hoverColorLight || "#eef2f7",
@@ -99,6 +101,7 @@ export default function SearchProvider({
       const qp = new URLSearchParams({ q: query, count: "1" });
       if (regex) qp.set("regex", "1");
       if (caseSensitive) qp.set("case", "1");
+      if (handle) qp.set("handle", handle);
       const r = await fetch(`/api/search?${qp.toString()}`);
       if (!r.ok) return;
       const d = await r.json();
@@ -124,6 +127,7 @@ export default function SearchProvider({
     const qp = new URLSearchParams({ q: query });
     if (regex) qp.set("regex", "1");
     if (caseSensitive) qp.set("case", "1");
+    if (handle) qp.set("handle", handle);
     fetch(`/api/search?${qp.toString()}`, { signal: ctrl.signal })
       .then((r) => (r.ok ? r.json() : Promise.reject()))
       .then(async ({ Matches = [], Total }) => {
@@ -194,9 +198,9 @@ export default function SearchProvider({
   const copyRange = async () => {
     try {
       const { s: start, e: end } = clampRange();
-      const html = await fetch(`/api/range?start=${start - 1}&end=${end}`).then(
-        (r) => r.text(),
-      );
+      const html = await fetch(
+        `/api/range?start=${start - 1}&end=${end}${handleParam}`,
+      ).then((r) => r.text());
       const container = document.createElement("div");
       container.style.whiteSpace = "pre";
       if (streamMode) container.textContent = html;
@@ -229,13 +233,13 @@ export default function SearchProvider({
     const name = `${streamMode ? "chunks" : "lines"}_${start}-${end}.html`;
     const url = `/api/range?start=${
       start - 1
-    }&end=${end}&download=1&name=${encodeURIComponent(name)}`;
+    }&end=${end}&download=1&name=${encodeURIComponent(name)}${handleParam}`;
     window.open(url, "_blank");
   };
 
   async function fetchChunk(page, signal) {
     const start = page * pageSize;
-    const r = await fetch(`/api/chunk?start=${start}&count=${pageSize}`, {
+    const r = await fetch(`/api/chunk?start=${start}&count=${pageSize}${handleParam}`, {
       signal,
     });
     if (!r.ok) throw new Error("chunk");
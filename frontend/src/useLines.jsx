@@ -14,6 +14,8 @@ const EDGE_TOLERANCE = 2;
 const ALIGN_TOLERANCE = 16;
 const BUFFER_PAGES = 2;
 const SESSION_KEY = "biglog.lineSessions.v1";
+// The server closes handles idle for 30 minutes; touch ours well before that.
+const HANDLE_KEEPALIVE = 5 * 60 * 1000;
 
 function readSessionStore() {
   try {
@@ -58,6 +60,13 @@ function writeSessionLine(path, size, line) {
   writeSessionStore(Object.fromEntries(entries.slice(0, 100)));
 }
 
+function closeHandle(handle) {
+  fetch(`/api/close?handle=${encodeURIComponent(handle)}`, {
+    method: "POST",
+    keepalive: true,
+  }).catch(() => {});
+}
+
 function isNearTop(scroller) {
   return !scroller || scroller.scrollTop <= EDGE_TOLERANCE;
 }
@@ -89,6 +98,7 @@ export default function useLines(path, virt) {
   const [fileMode, setFileMode] = useState("line");
   const [fileSize, setFileSize] = useState(0);
   const [chunkSize, setChunkSize] = useState(0);
+  const [handle, setHandle] = useState("");
   const pageSize = fileMode === "byte" ? BYTE_PAGE : PAGE;
 
   const cache = useRef(new Map());
@@ -113,6 +123,7 @@ export default function useLines(path, virt) {
   const lastRange = useRef(null);
   const sessionTimer = useRef(0);
   const sessionMeta = useRef({ path: "", size: 0, mode: "line" });
+  const reopenHandle = useRef(() => {});
 
   const refreshFrame = useRef(0);
 
@@ -299,20 +310,33 @@ export default function useLines(path, virt) {
       const ctrl = new AbortController();
       pageCtrls.current.set(p, ctrl);
 
-      fetch(`/api/chunk?start=${start}&count=${pageSize}`, { signal: ctrl.signal })
-        .then((r) => (r.ok ? r.json() : Promise.reject()))
+      fetch(
+        `/api/chunk?start=${start}&count=${pageSize}&handle=${encodeURIComponent(handle)}`,
+        { signal: ctrl.signal },
+      )
+        .then((r) => {
+          if (r.status === 404) {
+            // The server closed the handle: idle too long, or evicted for
+            // newer ones. Open the file again and the page is fetched anew.
+            reopenHandle.current(handle);
+            return undefined;
+          }
+          return r.ok ? r.json() : Promise.reject();
+        })
         .then((lines) => {
-          if (ctrl.signal.aborted) return;
+          if (ctrl.signal.aborted || lines === undefined) return;
           cache.current.set(p, lines || []);
           scheduleRefresh();
         })
-        .catch(() => {})
+        .catch(() => {
+          if (!ctrl.signal.aborted) setError("failed to load lines");
+        })
         .finally(() => {
           pending.current.delete(p);
           pageCtrls.current.delete(p);
         });
     },
-    [lineCount, pageSize, scheduleRefresh],
+    [handle, lineCount, pageSize, scheduleRefresh],
   );
 
   const ensure = useCallback(
@@ -326,6 +350,25 @@ export default function useLines(path, virt) {
     [fetchPage, pageSize],
   );
 
+  useEffect(() => {
+    // Refill the visible rows once a reopened handle replaces the old one.
+    const range = lastRange.current;
+    if (!handle || !range) return;
+    ensure(range.base + range.startIndex, range.base + range.endIndex);
+  }, [ensure, handle]);
+
+  useEffect(() => {
+    if (!handle) return undefined;
+    const timer = window.setInterval(() => {
+      fetch(`/api/lines/status?handle=${encodeURIComponent(handle)}`)
+        .then((r) => {
+          if (r.status === 404) reopenHandle.current(handle);
+        })
+        .catch(() => {});
+    }, HANDLE_KEEPALIVE);
+    return () => window.clearInterval(timer);
+  }, [handle]);
+
   useEffect(() => {
     if (openCtrl.current) openCtrl.current.abort();
     pageCtrls.current.forEach((ctrl) => ctrl.abort());
@@ -351,17 +394,56 @@ export default function useLines(path, virt) {
     setFileMode("line");
     setFileSize(0);
     setChunkSize(0);
+    setHandle("");
     setTick((t) => t + 1);
 
     if (!path) return undefined;
 
     const ctrl = new AbortController();
     openCtrl.current = ctrl;
+    // Each viewer opens its own handle, so it never swaps out a file another
+    // viewer has open, and closes it when it moves on to another file.
+    let opened = "";
+    let reopening = false;
+
+    reopenHandle.current = (lost) => {
+      if (reopening || !opened || lost !== opened) return;
+      reopening = true;
+      fetch(`/api/open?path=${encodeURIComponent(path)}&handle=new`, { signal: ctrl.signal })
+        .then((r) => (r.ok ? r.json() : Promise.reject()))
+        .then((d) => {
+          if (ctrl.signal.aborted) {
+            if (d.Handle) closeHandle(d.Handle);
+            return;
+          }
+          opened = d.Handle || "";
+          const total = d.Lines || 0;
+          cache.current.clear();
+          pending.current.clear();
+          updateWindowState(
+            Math.min(baseRef.current, Math.max(0, total - Math.min(WINDOW_MAX, total))),
+            Math.min(WINDOW_MAX, Math.max(0, total)),
+          );
+          setLineCount(total);
+          setHandle(opened);
+        })
+        .catch(() => {
+          if (ctrl.signal.aborted) return;
+          setError("file was closed on the server and could not be reopened");
+        })
+        .finally(() => {
+          reopening = false;
+        });
+    };
 
-    fetch(`/api/open?path=${encodeURIComponent(path)}`, { signal: ctrl.signal })
+    fetch(`/api/open?path=${encodeURIComponent(path)}&handle=new`, { signal: ctrl.signal })
       .then((r) => (r.ok ? r.json() : Promise.reject()))
       .then((d) => {
-        if (ctrl.signal.aborted) return undefined;
+        if (ctrl.signal.aborted) {
+          if (d.Handle) closeHandle(d.Handle);
+          return undefined;
+        }
+        opened = d.Handle || "";
 
         const total = d.Lines || 0;
         const nextMode = d.Mode === "byte" ? "byte" : "line";
@@ -377,6 +459,7 @@ export default function useLines(path, virt) {
         setFileMode(nextMode);
         setFileSize(nextFileSize);
         setChunkSize(d.ChunkSize || 0);
+        setHandle(opened);
         sessionMeta.current = { path, size: nextFileSize, mode: nextMode };
         updateWindowState(initialBase, nextCount);
         setLineCount(total);
@@ -384,7 +467,12 @@ export default function useLines(path, virt) {
 
         if (nextMode === "byte") return null;
 
-        return fetch(`/api/chunk?start=${initialPage * nextPageSize}&count=${nextPageSize}`, {
+        const chunkParams = new URLSearchParams({
+          start: String(initialPage * nextPageSize),
+          count: String(nextPageSize),
+          handle: opened,
+        });
+        return fetch(`/api/chunk?${chunkParams.toString()}`, {
           signal: ctrl.signal,
         }).then((response) => ({
           response,
@@ -415,6 +503,7 @@ export default function useLines(path, virt) {
 
     return () => {
       ctrl.abort();
+      if (opened) closeHandle(opened);
     };
   }, [path, requestScroll, updateWindowState]);
 
@@ -623,6 +712,7 @@ export default function useLines(path, virt) {
     goLine,
     goMiddle,
     goTop,
+    handle,
     handleRange,
     ready,
     scrollerRef,
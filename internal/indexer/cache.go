@@ -0,0 +1,317 @@
+package indexer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const cacheMagic = "BLIDX4\n"
+const cacheFingerprintBytes int64 = 64 << 10
+
+var (
+	CacheDir                = defaultCacheDir()
+	CacheMaxBytes     int64 = 256 << 20
+	CacheMinFileBytes int64 = 8 << 20
+)
+
+var errCacheCorrupt = errors.New("index cache entry is corrupt")
+
+type cacheKey struct {
+	Path        string
+	Size        int64
+	ModTime     int64
+	Fingerprint [sha256.Size]byte
+	Encoding    string
+	LineEnding  string
+}
+
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil || dir == "" {
+		return filepath.Join(os.TempDir(), "biglog-index")
+	}
+	return filepath.Join(dir, "big-log-viewer", "index")
+}
+
+func newCacheKey(src Source, size int64, format lineFormat) (cacheKey, bool) {
+	if CacheDir == "" || size < CacheMinFileBytes || exceedsLineLimit(size, MaxIndexedBytes) {
+		return cacheKey{}, false
+	}
+	fp, err := fingerprint(src, size)
+	if err != nil {
+		return cacheKey{}, false
+	}
+	return cacheKey{
+		Path:        src.ID(),
+		Size:        size,
+		ModTime:     src.ModTime().UnixNano(),
+		Fingerprint: fp,
+		Encoding:    format.enc,
+		LineEnding:  format.ending,
+	}, true
+}
+
+func fingerprint(r io.ReaderAt, size int64) ([sha256.Size]byte, error) {
+	h := sha256.New()
+	var sizeBuf [8]byte
+	binary.LittleEndian.PutUint64(sizeBuf[:], uint64(size))
+	h.Write(sizeBuf[:])
+
+	headLen := cacheFingerprintBytes
+	if headLen > size {
+		headLen = size
+	}
+	buf := make([]byte, int(headLen))
+	if _, err := r.ReadAt(buf, 0); err != nil && err != io.EOF {
+		return [sha256.Size]byte{}, err
+	}
+	h.Write(buf)
+
+	if tailStart := size - cacheFingerprintBytes; tailStart > headLen {
+		if _, err := r.ReadAt(buf, tailStart); err != nil && err != io.EOF {
+			return [sha256.Size]byte{}, err
+		}
+		h.Write(buf)
+	}
+
+	var out [sha256.Size]byte
+	copy(out[:], h.Sum(nil))
+	return out, nil
+}
+
+func cacheEntryPath(key cacheKey) string {
+	sum := sha256.Sum256([]byte(key.Path))
+	return filepath.Join(CacheDir, hex.EncodeToString(sum[:16])+".idx")
+}
+
+func loadCachedIndex(key cacheKey, src Source) (*File, bool) {
+	entry := cacheEntryPath(key)
+	data, err := os.ReadFile(entry)
+	if err != nil {
+		return nil, false
+	}
+	lf, err := decodeCacheEntry(data, key)
+	if err != nil {
+		_ = os.Remove(entry)
+		return nil, false
+	}
+	now := time.Now()
+	_ = os.Chtimes(entry, now, now)
+	lf.Path = src.ID()
+	lf.Src = src
+	return lf, true
+}
+
+func storeCachedIndex(key cacheKey, lf *File) error {
+	if err := os.MkdirAll(CacheDir, 0o755); err != nil {
+		return err
+	}
+	entry := cacheEntryPath(key)
+	tmp, err := os.CreateTemp(CacheDir, filepath.Base(entry)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if _, err := tmp.Write(encodeCacheEntry(key, lf)); err != nil {
+		tmp.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, entry); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return pruneCache(entry)
+}
+
+func encodeCacheEntry(key cacheKey, lf *File) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(cacheMagic)
+	putString(&buf, key.Path)
+	putVarint(&buf, key.Size)
+	putVarint(&buf, key.ModTime)
+	buf.Write(key.Fingerprint[:])
+	putString(&buf, key.Encoding)
+	putString(&buf, key.LineEnding)
+	putString(&buf, lf.Mode)
+	putVarint(&buf, int64(lf.Lines))
+	putVarint(&buf, lf.ChunkSize)
+	putVarint(&buf, int64(lf.Base.Len()))
+	var prev int64
+	for i := 0; i < lf.Base.Len(); i++ {
+		off := lf.Base.At(i)
+		putVarint(&buf, off-prev)
+		prev = off
+	}
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], crc32.ChecksumIEEE(buf.Bytes()))
+	buf.Write(sum[:])
+	return buf.Bytes()
+}
+
+func decodeCacheEntry(data []byte, key cacheKey) (*File, error) {
+	if len(data) < len(cacheMagic)+4 || string(data[:len(cacheMagic)]) != cacheMagic {
+		return nil, errCacheCorrupt
+	}
+	body := data[:len(data)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[len(data)-4:]) {
+		return nil, errCacheCorrupt
+	}
+	r := bytes.NewReader(body[len(cacheMagic):])
+
+	path, err := readString(r)
+	if err != nil {
+		return nil, err
+	}
+	size, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, errCacheCorrupt
+	}
+	modTime, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, errCacheCorrupt
+	}
+	var fp [sha256.Size]byte
+	if _, err := io.ReadFull(r, fp[:]); err != nil {
+		return nil, errCacheCorrupt
+	}
+	enc, err := readString(r)
+	if err != nil {
+		return nil, err
+	}
+	ending, err := readString(r)
+	if err != nil {
+		return nil, err
+	}
+	if path != key.Path || size != key.Size || modTime != key.ModTime || fp != key.Fingerprint ||
+		enc != key.Encoding || ending != key.LineEnding {
+		return nil, errors.New("index cache entry is stale")
+	}
+
+	mode, err := readString(r)
+	if err != nil {
+		return nil, err
+	}
+	if mode != ModeLine && mode != ModeByte {
+		return nil, errCacheCorrupt
+	}
+	lines, err := binary.ReadVarint(r)
+	if err != nil || lines < 0 {
+		return nil, errCacheCorrupt
+	}
+	chunkSize, err := binary.ReadVarint(r)
+	if err != nil || chunkSize < 0 {
+		return nil, errCacheCorrupt
+	}
+	n, err := binary.ReadVarint(r)
+	if err != nil || n < 0 || n > int64(r.Len()) {
+		return nil, errCacheCorrupt
+	}
+	var base Offsets
+	var prev int64
+	for i := int64(0); i < n; i++ {
+		delta, err := binary.ReadVarint(r)
+		if err != nil || delta < 0 {
+			return nil, errCacheCorrupt
+		}
+		prev += delta
+		if prev > size {
+			return nil, errCacheCorrupt
+		}
+		base.Append(prev)
+	}
+	if r.Len() != 0 {
+		return nil, errCacheCorrupt
+	}
+	if mode == ModeLine && (int64(base.Len()) != (lines+Group-1)/Group) {
+		return nil, errCacheCorrupt
+	}
+	return &File{
+		Base:       base,
+		Lines:      int(lines),
+		Size:       size,
+		Mode:       mode,
+		ChunkSize:  chunkSize,
+		Encoding:   enc,
+		LineEnding: ending,
+	}, nil
+}
+
+func pruneCache(keep string) error {
+	entries, err := os.ReadDir(CacheDir)
+	if err != nil {
+		return err
+	}
+	type cacheFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	files := make([]cacheFile, 0, len(entries))
+	var total int64
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".idx" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cacheFile{
+			path:    filepath.Join(CacheDir, e.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for _, cf := range files {
+		if total <= CacheMaxBytes {
+			break
+		}
+		if cf.path == keep {
+			continue
+		}
+		if err := os.Remove(cf.path); err == nil {
+			total -= cf.size
+		}
+	}
+	return nil
+}
+
+func putVarint(buf *bytes.Buffer, v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutVarint(tmp[:], v)])
+}
+
+func putString(buf *bytes.Buffer, s string) {
+	putVarint(buf, int64(len(s)))
+	buf.WriteString(s)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	n, err := binary.ReadVarint(r)
+	if err != nil || n < 0 || n > int64(r.Len()) {
+		return "", errCacheCorrupt
+	}
+	b := make([]byte, int(n))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", errCacheCorrupt
+	}
+	return string(b), nil
+}
@@ -0,0 +1,148 @@
+package indexer
+
+import (
+	"crypto/sha256"
+	"os"
+	"sync"
+	"time"
+)
+
+// Change says how the data under an open File differs from what it indexed.
+type Change string
+
+const (
+	Unchanged       Change = ""
+	ChangeAppended  Change = "appended"
+	ChangeTruncated Change = "truncated"
+	ChangeRewritten Change = "rewritten"
+	ChangeReplaced  Change = "replaced"
+	ChangeDeleted   Change = "deleted"
+)
+
+// Stale reports whether the index no longer describes the data the File
+// reads. Appended data and a replaced or deleted path leave the indexed
+// bytes readable as they were.
+func (c Change) Stale() bool {
+	return c == ChangeTruncated || c == ChangeRewritten
+}
+
+// Checker is implemented by sources that can cheaply look at the object
+// they were opened from. same is false once the path names a different
+// object, and exists is false once nothing is there.
+type Checker interface {
+	Current() (size int64, modTime time.Time, same, exists bool, err error)
+}
+
+func (s *FileSource) Current() (int64, time.Time, bool, bool, error) {
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		return 0, time.Time{}, false, false, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, false, false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return info.Size(), info.ModTime(), os.SameFile(info, s.info), true, nil
+}
+
+func (s *MemorySource) Current() (int64, time.Time, bool, bool, error) {
+	return s.Size(), s.ModTime(), true, true, nil
+}
+
+// snapshot is what a File last saw of its raw source: the compressed file
+// for gzip logs, the source itself otherwise.
+type snapshot struct {
+	mu      sync.Mutex
+	size    int64
+	modTime time.Time
+	fp      [sha256.Size]byte
+}
+
+// raw returns the source whose bytes the snapshot describes.
+func (lf *File) raw() Source {
+	if gz, ok := lf.Src.(*gzipReaderAt); ok {
+		return gz.src
+	}
+	return lf.Src
+}
+
+// remember records the raw source's current size, modtime and checksum.
+func (lf *File) remember() {
+	if seen, ok := observe(lf.raw()); ok {
+		lf.snap.set(seen)
+	}
+}
+
+// sighting is one look at a source: its size, modtime and checksum.
+type sighting struct {
+	size    int64
+	modTime time.Time
+	fp      [sha256.Size]byte
+}
+
+func observe(src Source) (sighting, bool) {
+	if src == nil {
+		return sighting{}, false
+	}
+	size, modTime := src.Size(), src.ModTime()
+	if c, ok := src.(Checker); ok {
+		if cur, mod, same, exists, err := c.Current(); err == nil && same && exists {
+			size, modTime = cur, mod
+		}
+	}
+	fp, err := fingerprint(src, size)
+	if err != nil {
+		return sighting{}, false
+	}
+	return sighting{size, modTime, fp}, true
+}
+
+func (s *snapshot) set(seen sighting) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.size, s.modTime, s.fp = seen.size, seen.modTime, seen.fp
+}
+
+// Check compares the raw source with what the File last saw. A stat is all
+// it costs unless the size or modtime moved, in which case the head and
+// tail of the indexed bytes are checksummed again. Sources that can't be
+// checked always report Unchanged.
+func (lf *File) Check() (Change, error) {
+	src := lf.raw()
+	c, ok := src.(Checker)
+	if !ok {
+		return Unchanged, nil
+	}
+	size, modTime, same, exists, err := c.Current()
+	switch {
+	case err != nil:
+		return Unchanged, err
+	case !exists:
+		return ChangeDeleted, nil
+	case !same:
+		return ChangeReplaced, nil
+	}
+
+	lf.snap.mu.Lock()
+	defer lf.snap.mu.Unlock()
+	if size == lf.snap.size && modTime.Equal(lf.snap.modTime) {
+		return Unchanged, nil
+	}
+	if size < lf.snap.size {
+		return ChangeTruncated, nil
+	}
+	fp, err := fingerprint(src, lf.snap.size)
+	if err != nil {
+		return Unchanged, err
+	}
+	if fp != lf.snap.fp {
+		return ChangeRewritten, nil
+	}
+	if size > lf.snap.size {
+		return ChangeAppended, nil
+	}
+	// Touched but not modified.
+	lf.snap.modTime = modTime
+	return Unchanged, nil
+}
@@ -0,0 +1,216 @@
+package indexer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	EncodingUTF8        = "utf-8"
+	EncodingUTF16LE     = "utf-16le"
+	EncodingUTF16BE     = "utf-16be"
+	EncodingLatin1      = "latin-1"
+	EncodingWindows1252 = "windows-1252"
+)
+
+const encodingSampleBytes = 64 << 10
+
+// windows1252 maps 0x80-0x9F, the only range where Windows-1252 differs from
+// Latin-1. Unassigned bytes decode to U+FFFD.
+var windows1252 = [32]rune{
+	'€', '\uFFFD', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '\uFFFD', 'Ž', '\uFFFD',
+	'\uFFFD', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '\uFFFD', 'ž', 'Ÿ',
+}
+
+// ParseEncoding maps the names users are likely to type onto one of the
+// Encoding constants. An empty name or "auto" returns "".
+func ParseEncoding(name string) (string, error) {
+	key := strings.NewReplacer("-", "", "_", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(name)))
+	switch key {
+	case "", "auto":
+		return "", nil
+	case "utf8":
+		return EncodingUTF8, nil
+	case "utf16", "utf16le", "ucs2", "unicode":
+		return EncodingUTF16LE, nil
+	case "utf16be":
+		return EncodingUTF16BE, nil
+	case "latin1", "iso88591", "l1":
+		return EncodingLatin1, nil
+	case "windows1252", "cp1252", "ansi":
+		return EncodingWindows1252, nil
+	}
+	return "", fmt.Errorf("unsupported encoding %q", name)
+}
+
+// DetectEncoding guesses the encoding of a file from its first bytes: a BOM
+// wins, then a NUL pattern typical of UTF-16 ASCII text, then UTF-8
+// validity, and anything else is treated as Windows-1252 or Latin-1.
+func DetectEncoding(sample []byte) string {
+	switch {
+	case bytes.HasPrefix(sample, []byte{0xEF, 0xBB, 0xBF}):
+		return EncodingUTF8
+	case bytes.HasPrefix(sample, []byte{0xFF, 0xFE}):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(sample, []byte{0xFE, 0xFF}):
+		return EncodingUTF16BE
+	}
+
+	var evenNUL, oddNUL int
+	for i, b := range sample {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			evenNUL++
+		} else {
+			oddNUL++
+		}
+	}
+	if units := len(sample) / 2; units >= 2 {
+		if oddNUL*10 >= units*7 && evenNUL*10 < units {
+			return EncodingUTF16LE
+		}
+		if evenNUL*10 >= units*7 && oddNUL*10 < units {
+			return EncodingUTF16BE
+		}
+	}
+
+	if validUTF8Prefix(sample) {
+		return EncodingUTF8
+	}
+	for _, b := range sample {
+		if b >= 0x80 && b <= 0x9F {
+			return EncodingWindows1252
+		}
+	}
+	return EncodingLatin1
+}
+
+// validUTF8Prefix reports whether sample is UTF-8, allowing the sample to
+// end partway through a multi-byte sequence.
+func validUTF8Prefix(sample []byte) bool {
+	for i := 0; i < 3 && i < len(sample); i++ {
+		if utf8.Valid(sample[:len(sample)-i]) {
+			return true
+		}
+	}
+	return utf8.Valid(sample)
+}
+
+func detectFormatAt(r io.ReaderAt, size int64, opts OpenOptions) (lineFormat, error) {
+	n := int64(encodingSampleBytes)
+	if n > size {
+		n = size
+	}
+	sample := make([]byte, n)
+	read, err := r.ReadAt(sample, 0)
+	if err != nil && err != io.EOF {
+		return lineFormat{}, err
+	}
+	return detectFormat(sample[:read], opts), nil
+}
+
+func detectFormat(sample []byte, opts OpenOptions) lineFormat {
+	format := lineFormat{enc: opts.Encoding, ending: opts.LineEnding}
+	if format.enc == "" {
+		format.enc = DetectEncoding(sample)
+	}
+	if format.ending == "" {
+		format.ending = DetectLineEnding(sample, format.enc)
+	}
+	return format
+}
+
+func isUTF16(enc string) bool {
+	return enc == EncodingUTF16LE || enc == EncodingUTF16BE
+}
+
+// unitBytes is the size of one code unit, and so the alignment of every
+// line start, in enc.
+func unitBytes(enc string) int64 {
+	if isUTF16(enc) {
+		return 2
+	}
+	return 1
+}
+
+// DecodeRow is DecodeText for the row at byte offset pos. The row at 0
+// loses the file's byte order mark, which is part of no line.
+func DecodeRow(enc string, b []byte, pos int64) string {
+	s := DecodeText(enc, b)
+	if pos == 0 {
+		s = strings.TrimPrefix(s, "\ufeff")
+	}
+	return s
+}
+
+// DecodeText converts b from enc to UTF-8. A trailing odd byte of UTF-16
+// input and invalid sequences become U+FFFD.
+func DecodeText(enc string, b []byte) string {
+	switch enc {
+	case EncodingUTF16LE, EncodingUTF16BE:
+		units := make([]uint16, len(b)/2)
+		for i := range units {
+			if enc == EncodingUTF16LE {
+				units[i] = uint16(b[2*i]) | uint16(b[2*i+1])<<8
+			} else {
+				units[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+			}
+		}
+		s := string(utf16.Decode(units))
+		if len(b)%2 != 0 {
+			s += "\uFFFD"
+		}
+		return s
+	case EncodingLatin1, EncodingWindows1252:
+		var sb strings.Builder
+		sb.Grow(len(b))
+		for _, c := range b {
+			switch {
+			case c < 0x80:
+				sb.WriteByte(c)
+			case c <= 0x9F && enc == EncodingWindows1252:
+				sb.WriteRune(windows1252[c-0x80])
+			default:
+				sb.WriteRune(rune(c))
+			}
+		}
+		return sb.String()
+	}
+	return string(b)
+}
+
+// HasNonPrintable reports whether decoded text holds bytes that aren't
+// readable text: invalid UTF-8, NULs and other control characters. Tabs,
+// line ends, form feeds and the ESC that starts ANSI colors don't count.
+func HasNonPrintable(s string) bool {
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && n <= 1:
+			return true
+		case r == '\t', r == '\n', r == '\r', r == '\f', r == 0x1b:
+		case r < 0x20, r == 0x7f, r >= 0x80 && r < 0xa0:
+			return true
+		}
+		i += n
+	}
+	return false
+}
+
+// cutUTF8 returns the first n bytes of s, fewer if that would split a
+// character.
+func cutUTF8(s string, n int) string {
+	if n >= len(s) {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,234 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+)
+
+var ErrFileReplaced = errors.New("file was truncated or replaced")
+
+var errNotFollowable = errors.New("this source cannot be followed")
+
+// Growth describes what Extend added: rows from From onward are new or
+// changed, and Lines/Size/Mode are the file's totals afterwards.
+type Growth struct {
+	From  int
+	Lines int
+	Size  int64
+	Mode  string
+}
+
+func (lf *File) Extend() (Growth, error) {
+	x, err := lf.NewExtension()
+	if err != nil {
+		return Growth{}, err
+	}
+	if err := x.Run(); err != nil {
+		return Growth{}, err
+	}
+	return lf.ApplyExtension(x)
+}
+
+// Extension indexes what was appended to a File. Like LineScan it copies
+// what it needs from the File up front, so Run touches no File fields and
+// readers keep using the File while the new bytes are scanned.
+type Extension struct {
+	src     Source
+	size    int64
+	lines   int
+	mode    string
+	records *Records
+	grown   int64
+
+	// Line mode rescans from the last Group offset, and records from the
+	// record open at from.
+	format   lineFormat
+	maxBytes int64
+	from     int
+	group    int
+	groupPos int64
+	re       *regexp.Regexp
+	keep     int
+	last     int
+
+	base   Offsets
+	count  int
+	bytes  bool
+	starts []int
+	seen   sighting
+	seenOK bool
+	done   bool
+}
+
+// NewExtension looks for data appended to lf, returning ErrFileReplaced
+// when it was truncated or replaced instead. It must be called while lf
+// can't change, such as under a read lock; Run does the scanning.
+func (lf *File) NewExtension() (*Extension, error) {
+	if lf.Compressed {
+		return nil, errors.New("compressed files cannot be followed")
+	}
+	if lf.Src == nil {
+		return nil, os.ErrClosed
+	}
+	src, ok := lf.Src.(Refresher)
+	if !ok {
+		return nil, errNotFollowable
+	}
+	// Refresh catches truncation and rotation; only the checksum catches a
+	// file rewritten in place.
+	change, err := lf.Check()
+	if err != nil {
+		return nil, err
+	}
+	if change != Unchanged && change != ChangeAppended {
+		return nil, ErrFileReplaced
+	}
+	if err := src.Refresh(); err != nil {
+		return nil, err
+	}
+	size := lf.Src.Size()
+	if size < lf.Size {
+		return nil, ErrFileReplaced
+	}
+	return lf.extension(size)
+}
+
+func (lf *File) extension(size int64) (*Extension, error) {
+	x := &Extension{
+		src:     lf.Src,
+		size:    lf.Size,
+		lines:   lf.Lines,
+		mode:    lf.Mode,
+		records: lf.Records,
+		grown:   size,
+		format:  lf.format(),
+	}
+	if size == lf.Size || lf.Mode != ModeLine {
+		return x, nil
+	}
+	open, err := lf.continuedAt(lf.Size)
+	if err != nil {
+		return nil, err
+	}
+	x.from = lf.Lines
+	if open {
+		x.from--
+	}
+	x.maxBytes = MaxIndexedBytes
+	if lf.unlimited {
+		x.maxBytes = 0
+	}
+	if g := lf.Base.Len() - 1; g >= 0 {
+		x.group, x.groupPos = g, lf.Base.At(g)
+	}
+	if r := lf.Records; r != nil {
+		x.re = r.re
+		x.keep, x.last = r.keep(x.from)
+	}
+	return x, nil
+}
+
+// Run scans what NewExtension found appended.
+func (x *Extension) Run() error {
+	if x.grown > x.size && x.mode == ModeLine {
+		// The scan restarts at the last group, whose offset it records
+		// again, so that one is cut off in place rather than the index
+		// rebuilt.
+		s := lineScanner{format: x.format, pos: x.groupPos, rowStart: x.groupPos, lines: x.group * Group}
+		ok, err := s.scan(io.NewSectionReader(x.src, s.pos, x.grown-s.pos), x.maxBytes)
+		if err != nil {
+			return err
+		}
+		x.bytes = !ok
+		x.base, x.count = s.base, s.count()
+		if ok && x.records != nil {
+			// Rows of the view are numbered from the restarted group.
+			shift := x.group * Group
+			view := &File{Src: x.src, Base: x.base, Lines: x.count - shift, Size: x.grown, Encoding: x.format.enc, LineEnding: x.format.ending}
+			err := view.recordStarts(context.Background(), x.re, x.from, x.count, x.last, shift, func(row int) {
+				x.starts = append(x.starts, row)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if x.grown > x.size {
+		x.seen, x.seenOK = observe(x.src)
+	}
+	x.done = true
+	return nil
+}
+
+// ApplyExtension installs a finished extension and returns what it added.
+// When lf changed after NewExtension, such as by another Extend, lf is
+// extended again in place instead.
+func (lf *File) ApplyExtension(x *Extension) (Growth, error) {
+	if !x.done || lf.Src != x.src || lf.Size != x.size || lf.Lines != x.lines || lf.Mode != x.mode || lf.Records != x.records {
+		return lf.Extend()
+	}
+	if x.grown == x.size {
+		return Growth{From: lf.Lines, Lines: lf.Lines, Size: lf.Size, Mode: lf.Mode}, nil
+	}
+	var growth Growth
+	switch {
+	case lf.Mode == ModeByte:
+		growth = lf.extendBytes(x.grown)
+	case x.bytes:
+		lf.Base = Offsets{}
+		lf.Records = nil
+		lf.Mode = ModeByte
+		lf.ChunkSize = ByteChunkSize
+		lf.Size = x.grown
+		lf.Lines = byteModeFile(lf.Src, x.grown).Lines
+		growth = Growth{From: 0, Lines: lf.Lines, Size: lf.Size, Mode: lf.Mode}
+	default:
+		lf.Base.Truncate(x.group)
+		for i := 0; i < x.base.Len(); i++ {
+			lf.Base.Append(x.base.At(i))
+		}
+		lf.Lines = x.count
+		lf.Size = x.grown
+		if r := lf.Records; r != nil {
+			r.starts.Truncate(x.keep)
+			for _, row := range x.starts {
+				r.starts.Append(int64(row))
+			}
+			r.rows = lf.Lines
+		}
+		growth = Growth{From: x.from, Lines: lf.Lines, Size: lf.Size, Mode: lf.Mode}
+	}
+	if x.seenOK {
+		lf.snap.set(x.seen)
+	}
+	return growth, nil
+}
+
+func (lf *File) extendLines(size int64) (Growth, error) {
+	x, err := lf.extension(size)
+	if err != nil {
+		return Growth{}, err
+	}
+	if err := x.Run(); err != nil {
+		return Growth{}, err
+	}
+	return lf.ApplyExtension(x)
+}
+
+func (lf *File) extendBytes(size int64) Growth {
+	// The last row grows into the new data, and when it was partial its
+	// newline-aligned start may move too, which reshapes the row before it.
+	from := lf.Lines - 1
+	if lf.ChunkSize > 0 && lf.Size%lf.ChunkSize != 0 {
+		from--
+	}
+	if from < 0 {
+		from = 0
+	}
+	lf.Size = size
+	lf.Lines = byteModeFile(lf.Src, size).Lines
+	return Growth{From: from, Lines: lf.Lines, Size: size, Mode: lf.Mode}
+}
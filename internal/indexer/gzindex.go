@@ -0,0 +1,790 @@
+package indexer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+const gzWindowSize = 32 << 10
+const gzBufSize = gzWindowSize + 256<<10
+const gzFillBytes = 64 << 10
+
+var GzipCheckpointBytes int64 = 4 << 20
+
+var errGzipCorrupt = errors.New("gzip: corrupt deflate stream")
+
+const (
+	gzStateHeader = iota
+	gzStateBlock
+	gzStateStored
+	gzStateHuffman
+	gzStateTrailer
+	gzStateDone
+)
+
+var (
+	gzLenBase   = [29]int{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	gzLenExtra  = [29]uint{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	gzDistBase  = [30]int{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	gzDistExtra = [30]uint{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+	gzCLOrder   = [19]int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+	gzFixedOnce sync.Once
+	gzFixedLit  gzHuffman
+	gzFixedDist gzHuffman
+)
+
+// gzCheckpoint is a place where decompression can restart: either the
+// start of a gzip member or a deflate block boundary with its 32 KiB window.
+type gzCheckpoint struct {
+	Out    int64
+	In     int64
+	Bit    uint8
+	Member bool
+	Window []byte
+}
+
+type gzHuffman struct {
+	table  []uint16
+	maxLen uint
+}
+
+func (h *gzHuffman) init(lengths []uint8) error {
+	var count [16]int
+	var maxLen uint8
+	for _, l := range lengths {
+		count[l]++
+		if l > maxLen {
+			maxLen = l
+		}
+	}
+	h.maxLen = uint(maxLen)
+	if maxLen == 0 {
+		h.table = h.table[:0]
+		return nil
+	}
+	left := 1
+	for l := 1; l < 16; l++ {
+		left <<= 1
+		left -= count[l]
+		if left < 0 {
+			return errGzipCorrupt
+		}
+	}
+	var next [16]int
+	code := 0
+	count[0] = 0
+	for l := 1; l < 16; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	size := 1 << maxLen
+	if cap(h.table) < size {
+		h.table = make([]uint16, size)
+	} else {
+		h.table = h.table[:size]
+		clear(h.table)
+	}
+	for sym, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		rev := 0
+		for i := uint8(0); i < l; i++ {
+			rev = rev<<1 | (c>>i)&1
+		}
+		for i := rev; i < size; i += 1 << l {
+			h.table[i] = uint16(sym<<4) | uint16(l)
+		}
+	}
+	return nil
+}
+
+func gzFixedTables() (*gzHuffman, *gzHuffman) {
+	gzFixedOnce.Do(func() {
+		lengths := make([]uint8, 288)
+		for i := range lengths {
+			switch {
+			case i < 144:
+				lengths[i] = 8
+			case i < 256:
+				lengths[i] = 9
+			case i < 280:
+				lengths[i] = 7
+			default:
+				lengths[i] = 8
+			}
+		}
+		_ = gzFixedLit.init(lengths)
+		dist := make([]uint8, 30)
+		for i := range dist {
+			dist[i] = 5
+		}
+		_ = gzFixedDist.init(dist)
+	})
+	return &gzFixedLit, &gzFixedDist
+}
+
+type gzBits struct {
+	r     *bufio.Reader
+	in    int64
+	bits  uint64
+	nbits uint
+}
+
+func (b *gzBits) need(n uint) error {
+	for b.nbits < n {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		b.in++
+		b.bits |= uint64(c) << b.nbits
+		b.nbits += 8
+	}
+	return nil
+}
+
+func (b *gzBits) take(n uint) (int, error) {
+	if err := b.need(n); err != nil {
+		return 0, err
+	}
+	v := int(b.bits & (1<<n - 1))
+	b.bits >>= n
+	b.nbits -= n
+	return v, nil
+}
+
+func (b *gzBits) align() {
+	drop := b.nbits % 8
+	b.bits >>= drop
+	b.nbits -= drop
+}
+
+func (b *gzBits) readByte() (byte, error) {
+	v, err := b.take(8)
+	return byte(v), err
+}
+
+func (b *gzBits) bitPos() int64 {
+	return b.in*8 - int64(b.nbits)
+}
+
+func (b *gzBits) decode(h *gzHuffman) (int, error) {
+	if h.maxLen == 0 {
+		return 0, errGzipCorrupt
+	}
+	if b.nbits < h.maxLen {
+		if err := b.need(h.maxLen); err != nil && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+	}
+	e := h.table[b.bits&(1<<h.maxLen-1)]
+	l := uint(e & 15)
+	if e == 0 {
+		return 0, errGzipCorrupt
+	}
+	if l > b.nbits {
+		return 0, io.ErrUnexpectedEOF
+	}
+	b.bits >>= l
+	b.nbits -= l
+	return int(e >> 4), nil
+}
+
+// gzStream inflates gzip members from a byte position and can optionally
+// record checkpoints while doing so.
+type gzStream struct {
+	bits      gzBits
+	compSize  int64
+	hist      []byte
+	histStart int64
+	rpos      int
+	state     int
+	final     bool
+	stored    int
+	lit       *gzHuffman
+	dist      *gzHuffman
+	dynLit    gzHuffman
+	dynDist   gzHuffman
+
+	memberStart int64
+	crc         uint32
+	crcFrom     int
+	crcOK       bool
+	err         error
+
+	checkpoints []gzCheckpoint
+	record      bool
+	spacing     int64
+	lastCk      int64
+}
+
+func newGzStream(src io.ReaderAt, in, compSize int64) *gzStream {
+	return &gzStream{
+		bits: gzBits{
+			r:  bufio.NewReaderSize(io.NewSectionReader(src, in, compSize-in), 256<<10),
+			in: in,
+		},
+		compSize: compSize,
+		hist:     make([]byte, 0, gzBufSize),
+	}
+}
+
+func newGzRecorder(src io.ReaderAt, compSize int64) *gzStream {
+	s := newGzStream(src, 0, compSize)
+	s.record = true
+	s.spacing = GzipCheckpointBytes
+	return s
+}
+
+func (s *gzStream) pos() int64 {
+	return s.histStart + int64(s.rpos)
+}
+
+func (s *gzStream) written() int64 {
+	return s.histStart + int64(len(s.hist))
+}
+
+func (s *gzStream) Read(p []byte) (int, error) {
+	for s.rpos == len(s.hist) {
+		if s.err != nil {
+			return 0, s.err
+		}
+		s.fill()
+	}
+	n := copy(p, s.hist[s.rpos:])
+	s.rpos += n
+	return n, nil
+}
+
+func (s *gzStream) skip(n int64) error {
+	for n > 0 {
+		if s.rpos == len(s.hist) {
+			if s.err != nil {
+				if s.err == io.EOF {
+					return io.ErrUnexpectedEOF
+				}
+				return s.err
+			}
+			s.fill()
+			continue
+		}
+		step := int64(len(s.hist) - s.rpos)
+		if step > n {
+			step = n
+		}
+		s.rpos += int(step)
+		n -= step
+	}
+	return nil
+}
+
+func (s *gzStream) fill() {
+	s.compact()
+	limit := len(s.hist) + gzFillBytes
+	if limit > cap(s.hist) {
+		limit = cap(s.hist)
+	}
+	for s.err == nil && len(s.hist) < limit && cap(s.hist)-len(s.hist) >= 258 {
+		s.err = s.step(limit)
+	}
+}
+
+func (s *gzStream) compact() {
+	keep := len(s.hist) - gzWindowSize
+	if keep > s.rpos {
+		keep = s.rpos
+	}
+	if keep <= 0 || cap(s.hist)-len(s.hist) > gzFillBytes+258 {
+		return
+	}
+	s.updateCRC()
+	n := copy(s.hist, s.hist[keep:])
+	s.hist = s.hist[:n]
+	s.histStart += int64(keep)
+	s.rpos -= keep
+	s.crcFrom -= keep
+}
+
+func (s *gzStream) updateCRC() {
+	if s.crcOK && s.crcFrom < len(s.hist) {
+		s.crc = crc32.Update(s.crc, crc32.IEEETable, s.hist[s.crcFrom:])
+	}
+	s.crcFrom = len(s.hist)
+}
+
+func (s *gzStream) step(limit int) error {
+	switch s.state {
+	case gzStateHeader:
+		return s.readHeader()
+	case gzStateBlock:
+		return s.readBlockHeader()
+	case gzStateStored:
+		return s.copyStored(limit)
+	case gzStateHuffman:
+		return s.inflate(limit)
+	case gzStateTrailer:
+		return s.readTrailer()
+	default:
+		return io.EOF
+	}
+}
+
+func (s *gzStream) checkpoint(member bool) {
+	out := s.written()
+	if !s.record {
+		return
+	}
+	if n := len(s.checkpoints); member && n > 0 && s.checkpoints[n-1].Out == out {
+		s.checkpoints = s.checkpoints[:n-1]
+	} else if n > 0 && out-s.lastCk < s.spacing {
+		return
+	}
+	pos := s.bits.bitPos()
+	ck := gzCheckpoint{
+		Out:    out,
+		In:     pos / 8,
+		Bit:    uint8(pos % 8),
+		Member: member,
+	}
+	if !member {
+		window := s.hist
+		if avail := out - s.memberStart; int64(len(window)) > avail {
+			window = window[len(window)-int(avail):]
+		}
+		if len(window) > gzWindowSize {
+			window = window[len(window)-gzWindowSize:]
+		}
+		ck.Window = packWindow(window)
+	}
+	s.checkpoints = append(s.checkpoints, ck)
+	s.lastCk = out
+}
+
+func (s *gzStream) readHeader() error {
+	if s.bits.bitPos()/8 >= s.compSize && s.written() > 0 {
+		s.state = gzStateDone
+		return io.EOF
+	}
+	s.checkpoint(true)
+	var hdr [10]byte
+	for i := range hdr {
+		c, err := s.bits.readByte()
+		if err != nil {
+			if s.written() > 0 {
+				s.state = gzStateDone
+				return io.EOF
+			}
+			return err
+		}
+		hdr[i] = c
+	}
+	if hdr[0] != 0x1f || hdr[1] != 0x8b || hdr[2] != 8 {
+		if s.written() > 0 {
+			s.state = gzStateDone
+			return io.EOF
+		}
+		return errors.New("gzip: invalid header")
+	}
+	flags := hdr[3]
+	if flags&4 != 0 {
+		xlen, err := s.bits.take(16)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < xlen; i++ {
+			if _, err := s.bits.readByte(); err != nil {
+				return err
+			}
+		}
+	}
+	for _, bit := range []byte{8, 16} {
+		if flags&bit == 0 {
+			continue
+		}
+		for {
+			c, err := s.bits.readByte()
+			if err != nil {
+				return err
+			}
+			if c == 0 {
+				break
+			}
+		}
+	}
+	if flags&2 != 0 {
+		if _, err := s.bits.take(16); err != nil {
+			return err
+		}
+	}
+	s.updateCRC()
+	s.memberStart = s.written()
+	s.crc = 0
+	s.crcOK = true
+	s.state = gzStateBlock
+	return nil
+}
+
+func (s *gzStream) readBlockHeader() error {
+	s.checkpoint(false)
+	hdr, err := s.bits.take(3)
+	if err != nil {
+		return err
+	}
+	s.final = hdr&1 == 1
+	switch hdr >> 1 {
+	case 0:
+		s.bits.align()
+		n, err := s.bits.take(16)
+		if err != nil {
+			return err
+		}
+		nn, err := s.bits.take(16)
+		if err != nil {
+			return err
+		}
+		if n != ^nn&0xffff {
+			return errGzipCorrupt
+		}
+		s.stored = n
+		s.state = gzStateStored
+	case 1:
+		s.lit, s.dist = gzFixedTables()
+		s.state = gzStateHuffman
+	case 2:
+		if err := s.readDynamic(); err != nil {
+			return err
+		}
+		s.lit, s.dist = &s.dynLit, &s.dynDist
+		s.state = gzStateHuffman
+	default:
+		return errGzipCorrupt
+	}
+	return nil
+}
+
+func (s *gzStream) readDynamic() error {
+	hlit, err := s.bits.take(5)
+	if err != nil {
+		return err
+	}
+	hdist, err := s.bits.take(5)
+	if err != nil {
+		return err
+	}
+	hclen, err := s.bits.take(4)
+	if err != nil {
+		return err
+	}
+	hlit += 257
+	hdist++
+	hclen += 4
+	if hlit > 286 || hdist > 30 {
+		return errGzipCorrupt
+	}
+
+	var clLens [19]uint8
+	for i := 0; i < hclen; i++ {
+		v, err := s.bits.take(3)
+		if err != nil {
+			return err
+		}
+		clLens[gzCLOrder[i]] = uint8(v)
+	}
+	var cl gzHuffman
+	if err := cl.init(clLens[:]); err != nil {
+		return err
+	}
+
+	lengths := make([]uint8, hlit+hdist)
+	for i := 0; i < len(lengths); {
+		sym, err := s.bits.decode(&cl)
+		if err != nil {
+			return err
+		}
+		if sym < 16 {
+			lengths[i] = uint8(sym)
+			i++
+			continue
+		}
+		var rep int
+		var val uint8
+		switch sym {
+		case 16:
+			if i == 0 {
+				return errGzipCorrupt
+			}
+			val = lengths[i-1]
+			rep, err = s.bits.take(2)
+			rep += 3
+		case 17:
+			rep, err = s.bits.take(3)
+			rep += 3
+		default:
+			rep, err = s.bits.take(7)
+			rep += 11
+		}
+		if err != nil {
+			return err
+		}
+		if i+rep > len(lengths) {
+			return errGzipCorrupt
+		}
+		for ; rep > 0; rep-- {
+			lengths[i] = val
+			i++
+		}
+	}
+	if lengths[256] == 0 {
+		return errGzipCorrupt
+	}
+	if err := s.dynLit.init(lengths[:hlit]); err != nil {
+		return err
+	}
+	return s.dynDist.init(lengths[hlit:])
+}
+
+func (s *gzStream) endBlock() {
+	if s.final {
+		s.state = gzStateTrailer
+	} else {
+		s.state = gzStateBlock
+	}
+}
+
+func (s *gzStream) copyStored(limit int) error {
+	for s.stored > 0 && s.bits.nbits >= 8 && len(s.hist) < limit {
+		c, _ := s.bits.readByte()
+		s.hist = append(s.hist, c)
+		s.stored--
+	}
+	n := s.stored
+	if room := limit - len(s.hist); n > room {
+		n = room
+	}
+	if n > 0 {
+		start := len(s.hist)
+		s.hist = s.hist[:start+n]
+		got, err := io.ReadFull(s.bits.r, s.hist[start:])
+		s.bits.in += int64(got)
+		s.hist = s.hist[:start+got]
+		s.stored -= got
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+	}
+	if s.stored == 0 {
+		s.endBlock()
+	}
+	return nil
+}
+
+func (s *gzStream) inflate(limit int) error {
+	b := &s.bits
+	for len(s.hist) < limit && cap(s.hist)-len(s.hist) >= 258 {
+		sym, err := b.decode(s.lit)
+		if err != nil {
+			return err
+		}
+		if sym < 256 {
+			s.hist = append(s.hist, byte(sym))
+			continue
+		}
+		if sym == 256 {
+			s.endBlock()
+			return nil
+		}
+		sym -= 257
+		if sym >= len(gzLenBase) {
+			return errGzipCorrupt
+		}
+		extra, err := b.take(gzLenExtra[sym])
+		if err != nil {
+			return err
+		}
+		length := gzLenBase[sym] + extra
+		dsym, err := b.decode(s.dist)
+		if err != nil {
+			return err
+		}
+		if dsym >= len(gzDistBase) {
+			return errGzipCorrupt
+		}
+		extra, err = b.take(gzDistExtra[dsym])
+		if err != nil {
+			return err
+		}
+		dist := gzDistBase[dsym] + extra
+		if dist > len(s.hist) || int64(dist) > s.written()-s.memberStart {
+			return errGzipCorrupt
+		}
+		start := len(s.hist) - dist
+		if dist >= length {
+			s.hist = append(s.hist, s.hist[start:start+length]...)
+			continue
+		}
+		for i := 0; i < length; i++ {
+			s.hist = append(s.hist, s.hist[start+i])
+		}
+	}
+	return nil
+}
+
+func (s *gzStream) readTrailer() error {
+	s.bits.align()
+	sum, err := s.bits.take(16)
+	if err != nil {
+		return err
+	}
+	hi, err := s.bits.take(16)
+	if err != nil {
+		return err
+	}
+	isize, err := s.bits.take(16)
+	if err != nil {
+		return err
+	}
+	isizeHi, err := s.bits.take(16)
+	if err != nil {
+		return err
+	}
+	s.updateCRC()
+	if s.crcOK {
+		if uint32(sum|hi<<16) != s.crc {
+			return errors.New("gzip: checksum mismatch")
+		}
+		if uint32(isize|isizeHi<<16) != uint32(s.written()-s.memberStart) {
+			return errors.New("gzip: size mismatch")
+		}
+	}
+	s.state = gzStateHeader
+	return nil
+}
+
+func packWindow(window []byte) []byte {
+	var buf bytes.Buffer
+	zw, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	_, _ = zw.Write(window)
+	_ = zw.Close()
+	return buf.Bytes()
+}
+
+func unpackWindow(packed []byte) ([]byte, error) {
+	return io.ReadAll(flate.NewReader(bytes.NewReader(packed)))
+}
+
+// gzipReaderAt serves random reads from a gzip file using the checkpoints
+// recorded while indexing, keeping one cursor open for sequential reads.
+type gzipReaderAt struct {
+	src         Source
+	compSize    int64
+	size        int64
+	checkpoints []gzCheckpoint
+
+	mu  sync.Mutex
+	cur *gzStream
+}
+
+func (g *gzipReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+	if off >= g.size {
+		return 0, io.EOF
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s, err := g.streamAt(off)
+	if err != nil {
+		g.cur = nil
+		return 0, err
+	}
+	n, err := io.ReadFull(s, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	if err != nil && err != io.EOF {
+		g.cur = nil
+	}
+	return n, err
+}
+
+// ID, ModTime and Close are the compressed file's, so a gzipReaderAt is the
+// Source for the decompressed text.
+func (g *gzipReaderAt) ID() string         { return g.src.ID() }
+func (g *gzipReaderAt) Size() int64        { return g.size }
+func (g *gzipReaderAt) ModTime() time.Time { return g.src.ModTime() }
+func (g *gzipReaderAt) Close() error       { return g.src.Close() }
+
+func (g *gzipReaderAt) streamAt(off int64) (*gzStream, error) {
+	i := sort.Search(len(g.checkpoints), func(i int) bool {
+		return g.checkpoints[i].Out > off
+	}) - 1
+	if i < 0 {
+		return nil, fmt.Errorf("no gzip checkpoint before offset %d", off)
+	}
+	ck := g.checkpoints[i]
+
+	if s := g.cur; s != nil {
+		if off >= s.histStart && off <= s.written() {
+			s.rpos = int(off - s.histStart)
+			return s, nil
+		}
+		if pos := s.pos(); pos <= off && pos >= ck.Out {
+			if err := s.skip(off - pos); err != nil {
+				return nil, err
+			}
+			return s, nil
+		}
+	}
+
+	s, err := g.resume(ck)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.skip(off - ck.Out); err != nil {
+		return nil, err
+	}
+	g.cur = s
+	return s, nil
+}
+
+func (g *gzipReaderAt) resume(ck gzCheckpoint) (*gzStream, error) {
+	s := newGzStream(g.src, ck.In, g.compSize)
+	s.histStart = ck.Out
+	s.memberStart = ck.Out
+	if ck.Member {
+		s.state = gzStateHeader
+		return s, nil
+	}
+	window, err := unpackWindow(ck.Window)
+	if err != nil {
+		return nil, err
+	}
+	s.hist = append(s.hist, window...)
+	s.histStart = ck.Out - int64(len(window))
+	s.memberStart = s.histStart
+	s.rpos = len(s.hist)
+	s.crcFrom = len(s.hist)
+	s.state = gzStateBlock
+	if ck.Bit > 0 {
+		if _, err := s.bits.take(uint(ck.Bit)); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
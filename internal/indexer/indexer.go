@@ -2,20 +2,23 @@ package indexer
 
 import (
 	"bufio"
-	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"math"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
 const Group = 256
-const MaxIndexedBytes int64 = 4 << 30
 const MaxIndexedLineBytes int64 = 4 << 20
 const ByteChunkSize int64 = 8 << 10
 
+var MaxIndexedBytes int64 = 64 << 30
+
+var errRangeDone = errors.New("range done")
+
 const (
 	ModeLine = "line"
 	ModeByte = "byte"
@@ -23,171 +26,289 @@ const (
 
 type File struct {
 	Path           string
-	File           *os.File
-	Base           []int64
+	Src            Source
+	Base           Offsets
 	Lines          int
 	Size           int64
 	Mode           string
 	ChunkSize      int64
-	TempPath       string
 	Compressed     bool
 	CompressedSize int64
+	Encoding       string
+	LineEnding     string
+	Records        *Records
+	Options        OpenOptions
+
+	stamps    stampIndex
+	snap      snapshot
+	unlimited bool
 }
 
-func Open(path string) (*File, error) {
-	if IsGzipPath(path) {
-		return openGzip(path)
-	}
+// OpenOptions forces settings that Open otherwise detects from the start of
+// the file. Empty fields mean detect.
+type OpenOptions struct {
+	Encoding   string
+	LineEnding string
+}
 
-	f, err := os.Open(path)
+func Open(ctx context.Context, path string, onProgress ProgressFunc) (*File, error) {
+	return OpenWith(ctx, path, OpenOptions{}, onProgress)
+}
+
+func OpenWith(ctx context.Context, path string, opts OpenOptions, onProgress ProgressFunc) (*File, error) {
+	src, err := OpenFileSource(path)
 	if err != nil {
 		return nil, err
 	}
-	info, err := f.Stat()
+	return OpenSource(ctx, src, opts, onProgress)
+}
+
+// OpenSource indexes src, which the returned File takes ownership of. src is
+// closed if indexing fails.
+func OpenSource(ctx context.Context, src Source, opts OpenOptions, onProgress ProgressFunc) (*File, error) {
+	var lf *File
+	var err error
+	if IsGzipPath(src.ID()) {
+		lf, err = openGzip(ctx, src, opts, onProgress)
+	} else {
+		lf, err = openPlain(ctx, src, opts, onProgress)
+	}
 	if err != nil {
-		f.Close()
+		src.Close()
 		return nil, err
 	}
-	return openPlain(path, f, info.Size())
+	lf.Options = opts
+	lf.remember()
+	return lf, nil
+}
+
+// IndexBytes estimates the memory held by lf's indexes.
+func (lf *File) IndexBytes() int64 {
+	n := lf.Base.Bytes()
+	if lf.Records != nil {
+		n += lf.Records.starts.Bytes()
+	}
+	if gz, ok := lf.Src.(*gzipReaderAt); ok {
+		for _, cp := range gz.checkpoints {
+			n += int64(len(cp.Window)) + 32
+		}
+	}
+	return n
 }
 
 func IsGzipPath(path string) bool {
 	return strings.EqualFold(filepath.Ext(path), ".gz")
 }
 
-func openGzip(path string) (*File, error) {
-	info, err := os.Stat(path)
-	if err != nil {
-		return nil, err
+func openGzip(ctx context.Context, src Source, opts OpenOptions, onProgress ProgressFunc) (*File, error) {
+	compSize := src.Size()
+	stream := newGzRecorder(src, compSize)
+	rep := newProgress(ctx, onProgress, PhaseDecompressing, compSize)
+	br := bufio.NewReaderSize(stream, encodingSampleBytes)
+	sample, _ := br.Peek(encodingSampleBytes)
+	format := detectFormat(sample, opts)
+	base, n, lineMode, err := scanLines(br, format, MaxIndexedBytes, func(_ int64, lines int) error {
+		return rep.update(stream.bits.in, lines)
+	})
+	if err == nil && !lineMode {
+		err = drainGzip(stream, rep)
 	}
-	tempPath, cleanup, err := DecompressGzipToTemp(path)
 	if err != nil {
 		return nil, err
 	}
-	f, err := os.Open(tempPath)
-	if err != nil {
-		cleanup()
-		return nil, err
+	gz := &gzipReaderAt{
+		src:         src,
+		compSize:    compSize,
+		size:        stream.written(),
+		checkpoints: stream.checkpoints,
+	}
+
+	var lf *File
+	if lineMode {
+		lf = &File{
+			Path:  gz.ID(),
+			Src:   gz,
+			Base:  base,
+			Lines: n,
+			Size:  gz.size,
+			Mode:  ModeLine,
+		}
+	} else {
+		lf = byteModeFile(gz, gz.size)
+	}
+	lf.Encoding = format.enc
+	lf.LineEnding = format.ending
+	lf.Compressed = true
+	lf.CompressedSize = compSize
+	return lf, nil
+}
+
+func drainGzip(stream *gzStream, rep *progress) error {
+	buf := make([]byte, 256<<10)
+	for {
+		_, err := stream.Read(buf)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := rep.update(stream.bits.in, 0); err != nil {
+			return err
+		}
 	}
-	tempInfo, err := f.Stat()
+}
+
+func openPlain(ctx context.Context, src Source, opts OpenOptions, onProgress ProgressFunc) (*File, error) {
+	size := src.Size()
+	format, err := detectFormatAt(src, size, opts)
 	if err != nil {
-		f.Close()
-		cleanup()
 		return nil, err
 	}
-	lf, err := openPlain(path, f, tempInfo.Size())
+	key, cacheable := newCacheKey(src, size, format)
+	if cacheable {
+		if lf, ok := loadCachedIndex(key, src); ok {
+			return lf, nil
+		}
+	}
+	lf, err := indexPlain(src, size, format, newProgress(ctx, onProgress, PhaseIndexing, size))
 	if err != nil {
-		cleanup()
 		return nil, err
 	}
-	lf.TempPath = tempPath
-	lf.Compressed = true
-	lf.CompressedSize = info.Size()
+	lf.Encoding = format.enc
+	lf.LineEnding = format.ending
+	if cacheable {
+		_ = storeCachedIndex(key, lf)
+	}
 	return lf, nil
 }
 
-func DecompressGzipToTemp(path string) (string, func(), error) {
-	src, err := os.Open(path)
-	if err != nil {
-		return "", nil, err
+func indexPlain(src Source, size int64, format lineFormat, rep *progress) (*File, error) {
+	if exceedsLineLimit(size, MaxIndexedBytes) {
+		return byteModeFile(src, size), nil
 	}
-	defer src.Close()
-
-	gz, err := gzip.NewReader(src)
-	if err != nil {
-		return "", nil, err
+	var base Offsets
+	var n int
+	var lineMode bool
+	var err error
+	if format.unit() != 1 || format.bareCR() {
+		base, n, lineMode, err = scanLines(io.NewSectionReader(src, 0, size), format, MaxIndexedBytes, rep.update)
+	} else {
+		base, n, lineMode, err = scanLinesParallel(src, size, 0, MaxIndexedBytes, rep)
 	}
-	defer gz.Close()
-
-	base := filepath.Base(path)
-	suffix := filepath.Ext(strings.TrimSuffix(base, filepath.Ext(base)))
-	tmp, err := os.CreateTemp("", "biglog-gzip-*"+suffix)
 	if err != nil {
-		return "", nil, err
-	}
-	tempPath := tmp.Name()
-	cleanup := func() { _ = os.Remove(tempPath) }
-	if _, err := io.Copy(tmp, gz); err != nil {
-		tmp.Close()
-		cleanup()
-		return "", nil, err
+		return nil, err
 	}
-	if err := tmp.Close(); err != nil {
-		cleanup()
-		return "", nil, err
+	if !lineMode {
+		return byteModeFile(src, size), nil
 	}
-	return tempPath, cleanup, nil
+	return &File{
+		Path:      src.ID(),
+		Src:       src,
+		Base:      base,
+		Lines:     n,
+		Size:      size,
+		Mode:      ModeLine,
+		ChunkSize: 0,
+	}, nil
 }
 
-func openPlain(path string, f *os.File, size int64) (*File, error) {
-	if size > MaxIndexedBytes {
-		return byteModeFile(path, f, size), nil
+func scanLines(src io.Reader, format lineFormat, maxBytes int64, report func(pos int64, lines int) error) (Offsets, int, bool, error) {
+	s := lineScanner{format: format, report: report}
+	ok, err := s.scan(src, maxBytes)
+	if err != nil || !ok {
+		return Offsets{}, 0, false, err
 	}
+	return s.base, s.count(), true, nil
+}
 
-	base := make([]int64, 0, 1024)
-	r := bufio.NewReaderSize(f, 1<<20)
-
-	var pos int64
-	var lineStart int64
-	var lineBytes int64
-	var n int
-	var lineStarted bool
+type lineScanner struct {
+	base     Offsets
+	pos      int64
+	rowStart int64
+	rowBytes int64
+	lines    int
+	open     bool
+	format   lineFormat
+	report   func(pos int64, lines int) error
+}
 
+// scan records the start of every Group-th row. A row normally ends at a
+// newline, but a line longer than MaxIndexedLineBytes is cut into rows of
+// exactly that size so a single huge line doesn't push the file to byte mode.
+func (s *lineScanner) scan(src io.Reader, maxBytes int64) (bool, error) {
+	r := newLineReader(src, s.format, 1<<20)
 	for {
-		b, err := r.ReadSlice('\n')
-		if len(b) > 0 {
-			if !lineStarted {
-				if n%Group == 0 {
-					base = append(base, lineStart)
+		b, err := r.ReadSlice()
+		for len(b) > 0 {
+			if s.open && s.rowBytes == MaxIndexedLineBytes {
+				s.endRow()
+			}
+			if !s.open {
+				if s.lines%Group == 0 {
+					s.base.Append(s.rowStart)
 				}
-				lineStarted = true
+				s.open = true
 			}
-			pos += int64(len(b))
-			lineBytes += int64(len(b))
-			if lineBytes > MaxIndexedLineBytes {
-				return byteModeFile(path, f, size), nil
+			take := int64(len(b))
+			if room := MaxIndexedLineBytes - s.rowBytes; take > room {
+				take = room
+			}
+			s.pos += take
+			s.rowBytes += take
+			b = b[take:]
+		}
+		if exceedsLineLimit(s.pos, maxBytes) {
+			return false, nil
+		}
+		if s.report != nil {
+			if reportErr := s.report(s.pos, s.lines); reportErr != nil {
+				return false, reportErr
 			}
 		}
 		if err == bufio.ErrBufferFull {
 			continue
 		}
 		if err == io.EOF {
-			if lineStarted {
-				n++
-			}
-			break
+			return true, nil
 		}
 		if err != nil {
-			f.Close()
-			return nil, err
+			return false, err
 		}
-		if lineStarted {
-			n++
-		}
-		lineStarted = false
-		lineBytes = 0
-		lineStart = pos
+		s.endRow()
 	}
+}
 
-	return &File{
-		Path:      path,
-		File:      f,
-		Base:      base,
-		Lines:     n,
-		Size:      size,
-		Mode:      ModeLine,
-		ChunkSize: 0,
-	}, nil
+func (s *lineScanner) endRow() {
+	s.lines++
+	s.open = false
+	s.rowBytes = 0
+	s.rowStart = s.pos
+}
+
+func (s *lineScanner) count() int {
+	if s.open {
+		return s.lines + 1
+	}
+	return s.lines
+}
+
+func ExceedsLineLimit(size int64) bool {
+	return exceedsLineLimit(size, MaxIndexedBytes)
+}
+
+func exceedsLineLimit(n, limit int64) bool {
+	return limit > 0 && n > limit
 }
 
-func byteModeFile(path string, f *os.File, size int64) *File {
+func byteModeFile(src Source, size int64) *File {
 	lines := 0
 	if size > 0 {
 		lines = int((size + ByteChunkSize - 1) / ByteChunkSize)
 	}
 	return &File{
-		Path:      path,
-		File:      f,
+		Path:      src.ID(),
+		Src:       src,
 		Lines:     lines,
 		Size:      size,
 		Mode:      ModeByte,
@@ -195,17 +316,18 @@ func byteModeFile(path string, f *os.File, size int64) *File {
 	}
 }
 
+func (lf *File) ReadAt(p []byte, off int64) (int, error) {
+	if lf.Src == nil {
+		return 0, os.ErrClosed
+	}
+	return lf.Src.ReadAt(p, off)
+}
+
 func (lf *File) Close() error {
 	var err error
-	if lf.File != nil {
-		err = lf.File.Close()
-		lf.File = nil
-	}
-	if lf.TempPath != "" {
-		if removeErr := os.Remove(lf.TempPath); err == nil {
-			err = removeErr
-		}
-		lf.TempPath = ""
+	if lf.Src != nil {
+		err = lf.Src.Close()
+		lf.Src = nil
 	}
 	return err
 }
@@ -214,38 +336,13 @@ func (lf *File) LinesSlice(start, count int) ([]string, error) {
 	if lf.Mode == ModeByte {
 		return lf.byteSlice(start, count)
 	}
-	if start < 0 || start > lf.Lines {
-		return nil, fmt.Errorf("start out of range")
-	}
-	if start == lf.Lines {
-		return []string{}, nil
-	}
-	end := start + count
-	if end > lf.Lines {
-		end = lf.Lines
+	rows, err := lf.SliceRows(start, count)
+	if err != nil {
+		return nil, err
 	}
-	out := make([]string, end-start)
-
-	grp := start / Group
-	if grp >= len(lf.Base) {
-		return nil, fmt.Errorf("index out of range")
-	}
-	pos := lf.Base[grp]
-
-	sr := io.NewSectionReader(lf.File, pos, math.MaxInt64)
-	r := bufio.NewReaderSize(sr, 1<<20)
-
-	for i := grp * Group; i < end; i++ {
-		line, err := r.ReadBytes('\n')
-		if err != nil && err != io.EOF {
-			return nil, err
-		}
-		if i >= start {
-			out[i-start] = string(line)
-		}
-		if err == io.EOF {
-			break
-		}
+	out := make([]string, len(rows))
+	for i, row := range rows {
+		out[i] = row.Text
 	}
 	return out, nil
 }
@@ -262,26 +359,59 @@ func (lf *File) byteSlice(start, count int) ([]string, error) {
 		end = lf.Lines
 	}
 	out := make([]string, end-start)
+	scratch := make([]byte, lf.ChunkSize)
+	from, err := lf.chunkStart(start, scratch)
+	if err != nil {
+		return nil, err
+	}
 	for i := start; i < end; i++ {
-		offset := int64(i) * lf.ChunkSize
-		size := lf.ChunkSize
-		if remaining := lf.Size - offset; remaining < size {
-			size = remaining
-		}
-		if size <= 0 {
-			out[i-start] = ""
-			continue
+		to, err := lf.chunkStart(i+1, scratch)
+		if err != nil {
+			return nil, err
 		}
-		buf := make([]byte, int(size))
-		n, err := lf.File.ReadAt(buf, offset)
+		buf := make([]byte, int(to-from))
+		n, err := lf.ReadAt(buf, from)
 		if err != nil && err != io.EOF {
 			return nil, err
 		}
-		out[i-start] = string(buf[:n])
+		out[i-start] = DecodeRow(lf.Encoding, buf[:n], from)
+		from = to
 	}
 	return out, nil
 }
 
+// chunkStart returns where byte-mode row i begins: just past the first
+// newline in the ChunkSize bytes around i*ChunkSize, or at i*ChunkSize when
+// there is none. Rows then begin on line boundaries wherever lines are
+// shorter than a chunk, stay under 2*ChunkSize, and need no stored table.
+func (lf *File) chunkStart(i int, scratch []byte) (int64, error) {
+	nominal := int64(i) * lf.ChunkSize
+	if i <= 0 || lf.ChunkSize <= 0 {
+		return 0, nil
+	}
+	if nominal >= lf.Size {
+		return lf.Size, nil
+	}
+	format := lf.format()
+	unit := format.unit()
+	from := nominal - unit
+	n := lf.ChunkSize - unit
+	if from+n > lf.Size {
+		n = lf.Size - from
+	}
+	buf := scratch[:n]
+	read, err := lf.ReadAt(buf, from)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if end := format.end(buf[:read], from+int64(read) >= lf.Size); end >= 0 && from+int64(end) < lf.Size {
+		return from + int64(end), nil
+	}
+	return lf.AlignOffset(nominal), nil
+}
+
+// WriteRange writes rows start..end-1 as UTF-8. A range that begins or ends
+// inside a split line is widened so the original line is written whole.
 func (lf *File) WriteRange(w io.Writer, start, end int) error {
 	if lf.Mode == ModeByte {
 		return lf.writeByteRange(w, start, end)
@@ -292,37 +422,29 @@ func (lf *File) WriteRange(w io.Writer, start, end int) error {
 	if end > lf.Lines {
 		end = lf.Lines
 	}
-	if end < start {
-		end = start
-	}
-
-	grp := start / Group
-	if grp >= len(lf.Base) && !(start == 0 && len(lf.Base) == 0) {
-		return fmt.Errorf("index out of range")
+	if end <= start {
+		return nil
 	}
-	var pos int64
-	if len(lf.Base) > 0 {
-		pos = lf.Base[grp]
+	start, err := lf.LineStart(start)
+	if err != nil {
+		return err
 	}
 
-	sr := io.NewSectionReader(lf.File, pos, math.MaxInt64)
-	r := bufio.NewReaderSize(sr, 1<<20)
-
-	for i := grp * Group; i < end; i++ {
-		line, err := r.ReadBytes('\n')
-		if err != nil && err != io.EOF {
-			return err
+	err = lf.eachRow(start, lf.Lines, func(i int, pos int64, row []byte, continued bool) error {
+		if i >= end && !continued {
+			return errRangeDone
 		}
-		if i >= start {
-			if _, err := w.Write(line); err != nil {
-				return err
-			}
-		}
-		if err == io.EOF {
-			break
+		if lf.Encoding != "" && lf.Encoding != EncodingUTF8 {
+			_, err := io.WriteString(w, DecodeRow(lf.Encoding, row, pos))
+			return err
 		}
+		_, err := w.Write(row)
+		return err
+	})
+	if err == errRangeDone {
+		return nil
 	}
-	return nil
+	return err
 }
 
 func (lf *File) writeByteRange(w io.Writer, start, end int) error {
@@ -332,26 +454,17 @@ func (lf *File) writeByteRange(w io.Writer, start, end int) error {
 	if end > lf.Lines {
 		end = lf.Lines
 	}
-	if end < start {
-		end = start
+	if end <= start {
+		return nil
 	}
-	buf := make([]byte, lf.ChunkSize)
-	for i := start; i < end; i++ {
-		offset := int64(i) * lf.ChunkSize
-		size := lf.ChunkSize
-		if remaining := lf.Size - offset; remaining < size {
-			size = remaining
-		}
-		if size <= 0 {
-			continue
-		}
-		n, err := lf.File.ReadAt(buf[:int(size)], offset)
-		if err != nil && err != io.EOF {
-			return err
-		}
-		if _, err := w.Write(buf[:n]); err != nil {
-			return err
-		}
+	scratch := make([]byte, lf.ChunkSize)
+	from, err := lf.chunkStart(start, scratch)
+	if err != nil {
+		return err
+	}
+	to, err := lf.chunkStart(end, scratch)
+	if err != nil {
+		return err
 	}
-	return nil
+	return lf.WriteText(w, from, to)
 }
@@ -1,11 +1,21 @@
 package indexer
 
 import (
+	"bufio"
+	"bytes"
 	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 func TestOpenLineMode(t *testing.T) {
@@ -14,7 +24,7 @@ func TestOpenLineMode(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	f, err := Open(path)
+	f, err := Open(context.Background(), path, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -53,7 +63,7 @@ func TestOpenGzipLineMode(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	f, err := Open(path)
+	f, err := Open(context.Background(), path, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -67,9 +77,6 @@ func TestOpenGzipLineMode(t *testing.T) {
 	if f.CompressedSize <= 0 {
 		t.Fatalf("compressed size = %d, want positive", f.CompressedSize)
 	}
-	if f.TempPath == "" {
-		t.Fatal("temp path is empty")
-	}
 
 	lines, err := f.LinesSlice(0, 2)
 	if err != nil {
@@ -78,44 +85,1355 @@ func TestOpenGzipLineMode(t *testing.T) {
 	if got := strings.Join(lines, ""); got != "alpha\nbeta\n" {
 		t.Fatalf("slice = %q", got)
 	}
-
-	tempPath := f.TempPath
 	if err := f.Close(); err != nil {
 		t.Fatal(err)
 	}
-	if _, err := os.Stat(tempPath); !os.IsNotExist(err) {
-		t.Fatalf("temp file still exists or stat failed unexpectedly: %v", err)
-	}
 }
 
-func TestOpenLongLineUsesByteMode(t *testing.T) {
+func TestOpenSplitsLongLineIntoContinuationRows(t *testing.T) {
 	path := filepath.Join(t.TempDir(), "huge.html")
-	longLine := strings.Repeat("x", int(MaxIndexedLineBytes)+1)
-	if err := os.WriteFile(path, []byte(longLine), 0o600); err != nil {
+	longLine := strings.Repeat("x", 2*int(MaxIndexedLineBytes)+9) + "\n"
+	body := "alpha\n" + longLine + "omega\n"
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Open(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if f.Mode != ModeLine || f.Lines != 5 {
+		t.Fatalf("mode = %q, lines = %d, want line mode with 5 rows", f.Mode, f.Lines)
+	}
+	rows, err := f.SliceRows(0, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLen := []int{6, int(MaxIndexedLineBytes), int(MaxIndexedLineBytes), 10, 6}
+	wantCont := []bool{false, false, true, true, false}
+	for i, row := range rows {
+		if len(row.Text) != wantLen[i] || row.Continued != wantCont[i] {
+			t.Fatalf("row %d: len=%d continued=%v, want len=%d continued=%v", i, len(row.Text), row.Continued, wantLen[i], wantCont[i])
+		}
+	}
+	if first, err := f.LineStart(3); err != nil || first != 1 {
+		t.Fatalf("LineStart(3) = %d, %v; want 1", first, err)
+	}
+
+	var out bytes.Buffer
+	if err := f.WriteRange(&out, 2, 3); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != longLine {
+		t.Fatalf("WriteRange(2, 3) wrote %d bytes, want the whole %d byte line", out.Len(), len(longLine))
+	}
+	out.Reset()
+	if err := f.WriteRange(&out, 0, 2); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "alpha\n"+longLine {
+		t.Fatalf("WriteRange(0, 2) wrote %d bytes, want %d", out.Len(), len("alpha\n"+longLine))
+	}
+}
+
+func useTestCache(t *testing.T) string {
+	t.Helper()
+	oldDir, oldMin, oldMax := CacheDir, CacheMinFileBytes, CacheMaxBytes
+	CacheDir = t.TempDir()
+	CacheMinFileBytes = 0
+	t.Cleanup(func() {
+		CacheDir, CacheMinFileBytes, CacheMaxBytes = oldDir, oldMin, oldMax
+	})
+	return CacheDir
+}
+
+func TestOpenReusesCachedIndex(t *testing.T) {
+	useTestCache(t)
+	path := filepath.Join(t.TempDir(), "cached.log")
+	body := strings.Repeat("line of text\n", Group*3+7)
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := Open(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+
+	src, err := OpenFileSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	key, ok := newCacheKey(src, int64(len(body)), lineFormat{enc: EncodingUTF8, ending: LineEndingLF})
+	if !ok {
+		t.Fatal("expected file to be cacheable")
+	}
+	cached, ok := loadCachedIndex(key, src)
+	if !ok {
+		t.Fatal("expected cache hit after first open")
+	}
+	if cached.Lines != first.Lines || cached.Base.Len() != first.Base.Len() {
+		t.Fatalf("cached index = %d lines/%d groups, want %d/%d", cached.Lines, cached.Base.Len(), first.Lines, first.Base.Len())
+	}
+	for i := 0; i < cached.Base.Len(); i++ {
+		if cached.Base.At(i) != first.Base.At(i) {
+			t.Fatalf("base[%d] = %d, want %d", i, cached.Base.At(i), first.Base.At(i))
+		}
+	}
+}
+
+func TestOpenRebuildsCorruptOrStaleCache(t *testing.T) {
+	dir := useTestCache(t)
+	path := filepath.Join(t.TempDir(), "stale.log")
+	if err := os.WriteFile(path, []byte("a\nb\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	entries, err := filepath.Glob(filepath.Join(dir, "*.idx"))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("cache entries = %v (%v), want 1", entries, err)
+	}
+	if err := os.WriteFile(entries[0], []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err = Open(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Lines != 2 {
+		t.Fatalf("lines after corrupt cache = %d, want 2", f.Lines)
+	}
+	f.Close()
+
+	if err := os.WriteFile(path, []byte("a\nb\nc\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err = Open(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if f.Lines != 3 {
+		t.Fatalf("lines after rewrite = %d, want 3", f.Lines)
+	}
+}
+
+func TestPruneCacheKeepsNewestEntry(t *testing.T) {
+	dir := useTestCache(t)
+	CacheMaxBytes = 1
+	for i, name := range []string{"a.idx", "b.idx", "c.idx"} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte("entry"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		stamp := time.Now().Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(p, stamp, stamp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pruneCache(filepath.Join(dir, "c.idx")); err != nil {
+		t.Fatal(err)
+	}
+	left, _ := filepath.Glob(filepath.Join(dir, "*.idx"))
+	if len(left) != 1 || filepath.Base(left[0]) != "c.idx" {
+		t.Fatalf("remaining cache entries = %v, want only c.idx", left)
+	}
+}
+
+func syntheticLog(lines int) []byte {
+	var b strings.Builder
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&b, "2025/10/06 15:29:%02d.%03d INFO worker-%d processed record %d checksum=%x\n", i%60, i%1000, i%7, i, i*2654435761)
+	}
+	return []byte(b.String())
+}
+
+func writeGzip(t testing.TB, path string, level int, members ...[]byte) {
+	t.Helper()
+	handle, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handle.Close()
+	for _, member := range members {
+		gz, err := gzip.NewWriterLevel(handle, level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := gz.Write(member); err != nil {
+			t.Fatal(err)
+		}
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGzipRandomAccessMatchesPlainText(t *testing.T) {
+	old := GzipCheckpointBytes
+	GzipCheckpointBytes = 64 << 10
+	t.Cleanup(func() { GzipCheckpointBytes = old })
+
+	body := syntheticLog(40000)
+	for _, level := range []int{gzip.NoCompression, gzip.BestSpeed, gzip.DefaultCompression, gzip.HuffmanOnly} {
+		t.Run(fmt.Sprintf("level%d", level), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sample.log.gz")
+			writeGzip(t, path, level, body)
+
+			f, err := Open(context.Background(), path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if f.Size != int64(len(body)) {
+				t.Fatalf("size = %d, want %d", f.Size, len(body))
+			}
+			if f.Lines != 40000 {
+				t.Fatalf("lines = %d, want 40000", f.Lines)
+			}
+			if len(f.Src.(*gzipReaderAt).checkpoints) < 2 {
+				t.Fatalf("checkpoints = %d, want several", len(f.Src.(*gzipReaderAt).checkpoints))
+			}
+
+			for _, off := range []int64{int64(len(body)) - 100, 0, 1 << 20, 70000, 3 << 20, 12345} {
+				want := body[off : off+100]
+				got := make([]byte, 100)
+				if _, err := f.ReadAt(got, off); err != nil {
+					t.Fatalf("ReadAt(%d): %v", off, err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("ReadAt(%d) = %q, want %q", off, got, want)
+				}
+			}
+
+			lines, err := f.LinesSlice(31000, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := strings.SplitAfter(string(body), "\n")[31000:31002]
+			if strings.Join(lines, "") != strings.Join(want, "") {
+				t.Fatalf("slice = %q, want %q", lines, want)
+			}
+		})
+	}
+}
+
+func TestGzipMultiMemberUsesMemberCheckpoints(t *testing.T) {
+	old := GzipCheckpointBytes
+	GzipCheckpointBytes = 32 << 10
+	t.Cleanup(func() { GzipCheckpointBytes = old })
+
+	body := syntheticLog(8000)
+	var members [][]byte
+	for start := 0; start < len(body); start += 48 << 10 {
+		end := start + 48<<10
+		if end > len(body) {
+			end = len(body)
+		}
+		members = append(members, body[start:end])
+	}
+	path := filepath.Join(t.TempDir(), "blocks.log.gz")
+	writeGzip(t, path, gzip.BestCompression, members...)
+
+	f, err := Open(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if f.Size != int64(len(body)) {
+		t.Fatalf("size = %d, want %d", f.Size, len(body))
+	}
+	memberCheckpoints := 0
+	for _, ck := range f.Src.(*gzipReaderAt).checkpoints {
+		if ck.Member {
+			memberCheckpoints++
+		}
+	}
+	if memberCheckpoints < len(members)/2 {
+		t.Fatalf("member checkpoints = %d, want at least %d", memberCheckpoints, len(members)/2)
+	}
+
+	var out bytes.Buffer
+	if err := f.WriteRange(&out, 0, f.Lines); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), body) {
+		t.Fatal("full range export does not match original text")
+	}
+}
+
+func TestExtendPicksUpAppendedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "growing.log")
+	body := strings.Repeat("row\n", Group+10) + "partial"
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if f.Lines != Group+11 {
+		t.Fatalf("lines = %d, want %d", f.Lines, Group+11)
+	}
+
+	appendFile(t, path, " done\nnext\n")
+	growth, err := f.Extend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if growth.From != Group+10 || growth.Lines != Group+12 || f.Lines != Group+12 {
+		t.Fatalf("growth = %+v, lines = %d", growth, f.Lines)
+	}
+	lines, err := f.LinesSlice(growth.From, growth.Lines-growth.From)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(lines, ""); got != "partial done\nnext\n" {
+		t.Fatalf("appended rows = %q", got)
+	}
+
+	growth, err = f.Extend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if growth.From != growth.Lines {
+		t.Fatalf("unchanged file reported growth %+v", growth)
+	}
+}
+
+func TestExtensionRunsWithoutTouchingFile(t *testing.T) {
+	src := NewMemorySource("app.log", []byte(strings.Repeat("2026-03-04 05:06:07 row\n", Group+3)))
+	f, err := OpenSource(context.Background(), src, OpenOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	recs, err := f.ScanRecords(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetRecords(recs); err != nil {
+		t.Fatal(err)
+	}
+
+	src.Append([]byte("2026-03-04 05:06:08 new\n  detail\n"))
+	x, err := f.NewExtension()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := x.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if f.Lines != Group+3 || f.Records.Len() != Group+3 {
+		t.Fatalf("Run changed the file: lines = %d, records = %d", f.Lines, f.Records.Len())
+	}
+	growth, err := f.ApplyExtension(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if growth.From != Group+3 || f.Lines != Group+5 || f.Records.Len() != Group+4 {
+		t.Fatalf("growth = %+v, lines = %d, records = %d", growth, f.Lines, f.Records.Len())
+	}
+
+	// An extension made before another Extend is redone rather than applied.
+	src.Append([]byte("2026-03-04 05:06:09 last\n"))
+	stale, err := f.NewExtension()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stale.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Extend(); err != nil {
+		t.Fatal(err)
+	}
+	src.Append([]byte("2026-03-04 05:06:10 after\n"))
+	if _, err := f.ApplyExtension(stale); err != nil {
+		t.Fatal(err)
+	}
+	if f.Lines != Group+7 || f.Records.Len() != Group+6 {
+		t.Fatalf("lines = %d, records = %d", f.Lines, f.Records.Len())
+	}
+	rows, err := f.LinesSlice(Group+5, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(rows, ""); got != "2026-03-04 05:06:09 last\n2026-03-04 05:06:10 after\n" {
+		t.Fatalf("rows = %q", got)
+	}
+}
+
+func TestExtendKeepsIndexInStepWithFreshOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "growing.log")
+	if err := os.WriteFile(path, []byte("first\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	for step := 0; step < 8; step++ {
+		var b strings.Builder
+		for i := 0; i < Group/3+step*7; i++ {
+			fmt.Fprintf(&b, "step %d line %d\n", step, i)
+		}
+		b.WriteString("partial ")
+		if _, err := file.WriteString(b.String()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Extend(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fresh, err := Open(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fresh.Close()
+	if f.Lines != fresh.Lines || f.Base.Len() != fresh.Base.Len() {
+		t.Fatalf("lines = %d, groups = %d; fresh open has %d, %d", f.Lines, f.Base.Len(), fresh.Lines, fresh.Base.Len())
+	}
+	for g := 0; g < fresh.Base.Len(); g++ {
+		if f.Base.At(g) != fresh.Base.At(g) {
+			t.Fatalf("group %d starts at %d, want %d", g, f.Base.At(g), fresh.Base.At(g))
+		}
+	}
+}
+
+func TestExtendDetectsTruncationAndRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rotating.log")
+	if err := os.WriteFile(path, []byte("one\ntwo\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := os.WriteFile(path, []byte("x\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Extend(); err != ErrFileReplaced {
+		t.Fatalf("truncated extend err = %v, want ErrFileReplaced", err)
+	}
+
+	g, err := Open(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	next := filepath.Join(dir, "next.log")
+	if err := os.WriteFile(next, []byte("x\ny\nz\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(next, path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Extend(); err != ErrFileReplaced {
+		t.Fatalf("rotated extend err = %v, want ErrFileReplaced", err)
+	}
+}
+
+func TestOpenSourceReadsMemoryAndGzipSources(t *testing.T) {
+	src := NewMemorySource("mem.log", []byte("one\ntwo\nthree"))
+	f, err := OpenSource(context.Background(), src, OpenOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if f.Path != "mem.log" || f.Lines != 3 {
+		t.Fatalf("path = %q, lines = %d", f.Path, f.Lines)
+	}
+
+	src.Append([]byte("\nfour\n"))
+	select {
+	case <-src.Changes():
+	default:
+		t.Fatal("append did not notify")
+	}
+	growth, err := f.Extend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, err := f.LinesSlice(growth.From, growth.Lines-growth.From)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(lines, ""); got != "three\nfour\n" {
+		t.Fatalf("appended rows = %q", got)
+	}
+
+	var packed bytes.Buffer
+	zw := gzip.NewWriter(&packed)
+	zw.Write([]byte("alpha\nbeta\n"))
+	zw.Close()
+	g, err := OpenSource(context.Background(), NewMemorySource("mem.log.gz", packed.Bytes()), OpenOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	var out bytes.Buffer
+	if err := g.WriteRange(&out, 0, g.Lines); err != nil {
+		t.Fatal(err)
+	}
+	if !g.Compressed || out.String() != "alpha\nbeta\n" {
+		t.Fatalf("compressed = %v, text = %q", g.Compressed, out.String())
+	}
+}
+
+func TestHTTPSourceUsesRangeRequests(t *testing.T) {
+	var body []byte
+	for i := 0; i < 2000; i++ {
+		body = fmt.Appendf(body, "remote line %d\n", i)
+	}
+	var mu sync.Mutex
+	ranged := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Range") != "" {
+			ranged++
+		}
+		http.ServeContent(w, r, "remote.log", time.Time{}, bytes.NewReader(body))
+	}))
+	defer srv.Close()
+
+	src, err := NewHTTPSource(srv.Client(), srv.URL+"/remote.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := OpenSource(context.Background(), src, OpenOptions{}, nil)
+	if err != nil {
 		t.Fatal(err)
 	}
+	defer f.Close()
+	if f.Size != int64(len(body)) || f.Lines != 2000 {
+		t.Fatalf("size = %d, lines = %d", f.Size, f.Lines)
+	}
+	lines, err := f.LinesSlice(1500, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines[0] != "remote line 1500\n" || lines[1] != "remote line 1501\n" {
+		t.Fatalf("lines = %q", lines)
+	}
+
+	mu.Lock()
+	body = body[:100]
+	mu.Unlock()
+	if _, err := f.Extend(); err != ErrFileReplaced {
+		t.Fatalf("shrunk extend err = %v, want ErrFileReplaced", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if ranged == 0 {
+		t.Fatal("no range requests were made")
+	}
+}
+
+func appendFile(t *testing.T, path, text string) {
+	t.Helper()
+	handle, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handle.Close()
+	if _, err := handle.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+}
 
-	f, err := Open(path)
+func TestOffsetsRoundTrip(t *testing.T) {
+	var want []int64
+	var o Offsets
+	pos := int64(0)
+	for i := 0; i < 1000; i++ {
+		want = append(want, pos)
+		o.Append(pos)
+		pos += int64(i*i%9973) + 1
+	}
+	if o.Len() != len(want) {
+		t.Fatalf("len = %d, want %d", o.Len(), len(want))
+	}
+	for _, i := range []int{0, 1, 63, 64, 65, 500, 999} {
+		if got := o.At(i); got != want[i] {
+			t.Fatalf("At(%d) = %d, want %d", i, got, want[i])
+		}
+	}
+	if o.Bytes() >= int64(len(want))*8/2 {
+		t.Fatalf("compact encoding uses %d bytes for %d offsets", o.Bytes(), len(want))
+	}
+	head := o.Slice(100)
+	if head.Len() != 100 || head.At(99) != want[99] {
+		t.Fatalf("slice = %d entries, last %d", head.Len(), head.At(head.Len()-1))
+	}
+}
+
+func TestOpenHonorsConfigurableLineLimit(t *testing.T) {
+	old := MaxIndexedBytes
+	t.Cleanup(func() { MaxIndexedBytes = old })
+	path := filepath.Join(t.TempDir(), "limit.log")
+	if err := os.WriteFile(path, []byte("alpha\nbeta\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	MaxIndexedBytes = 4
+	f, err := Open(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Mode != ModeByte {
+		t.Fatalf("mode = %q with a 4 byte cap, want %q", f.Mode, ModeByte)
+	}
+	f.Close()
+
+	MaxIndexedBytes = 0
+	f, err = Open(context.Background(), path, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer f.Close()
+	if f.Mode != ModeLine || f.Lines != 2 {
+		t.Fatalf("uncapped open = %q/%d lines, want line mode with 2 lines", f.Mode, f.Lines)
+	}
+}
+
+func TestByteModeRowsStartOnLineBoundaries(t *testing.T) {
+	old := MaxIndexedBytes
+	MaxIndexedBytes = 1
+	t.Cleanup(func() { MaxIndexedBytes = old })
 
+	body := string(syntheticLog(2000)) + strings.Repeat("q", int(3*ByteChunkSize)) + "\nend"
+	path := filepath.Join(t.TempDir(), "aligned.log")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
 	if f.Mode != ModeByte {
 		t.Fatalf("mode = %q, want %q", f.Mode, ModeByte)
 	}
-	if f.ChunkSize != ByteChunkSize {
-		t.Fatalf("chunk size = %d, want %d", f.ChunkSize, ByteChunkSize)
+
+	rows, err := f.LinesSlice(0, f.Lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var joined strings.Builder
+	for i, row := range rows {
+		if row == "" || int64(len(row)) >= 2*ByteChunkSize {
+			t.Fatalf("row %d has %d bytes", i, len(row))
+		}
+		starts := joined.Len()
+		if i > 0 && body[starts-1] != '\n' && !strings.HasPrefix(row, "q") {
+			t.Fatalf("row %d starts mid-line: %q", i, row[:20])
+		}
+		joined.WriteString(row)
+	}
+	if joined.String() != body {
+		t.Fatal("rows do not add back up to the file")
+	}
+
+	var out bytes.Buffer
+	if err := f.WriteRange(&out, 2, 5); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != rows[2]+rows[3]+rows[4] {
+		t.Fatalf("WriteRange(2, 5) wrote %d bytes, want rows 2-4", out.Len())
+	}
+}
+
+func encodeUTF16(s string, bigEndian bool) []byte {
+	units := utf16.Encode([]rune(s))
+	out := make([]byte, 0, 2*len(units))
+	for _, u := range units {
+		if bigEndian {
+			out = append(out, byte(u>>8), byte(u))
+		} else {
+			out = append(out, byte(u), byte(u>>8))
+		}
+	}
+	return out
+}
+
+func TestOpenDetectsAndDecodesEncodings(t *testing.T) {
+	dir := t.TempDir()
+	// U+0A0D encodes as 0D 0A in UTF-16LE, which must not end a line.
+	text := "first line\nzweite Zeile \u00e4\u0a0d\U0001F600\nthird"
+	cases := []struct {
+		name string
+		body []byte
+		want string
+	}{
+		{"bom-le", append([]byte{0xFF, 0xFE}, encodeUTF16(text, false)...), EncodingUTF16LE},
+		{"bom-be", append([]byte{0xFE, 0xFF}, encodeUTF16(text, true)...), EncodingUTF16BE},
+		{"plain-le", encodeUTF16(text, false), EncodingUTF16LE},
+		{"cp1252", []byte("price \x80 5\nquote \x93ok\x94\nend"), EncodingWindows1252},
+		{"latin1", []byte("caf\xe9\nna\xefve\nend"), EncodingLatin1},
+		{"utf8", []byte("caf\xc3\xa9\nsecond\nend"), EncodingUTF8},
+	}
+	for _, tc := range cases {
+		path := filepath.Join(dir, tc.name+".log")
+		if err := os.WriteFile(path, tc.body, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		f, err := Open(context.Background(), path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Encoding != tc.want || f.Lines != 3 {
+			t.Fatalf("%s: encoding=%q lines=%d, want %q with 3 lines", tc.name, f.Encoding, f.Lines, tc.want)
+		}
+		rows, err := f.LinesSlice(0, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range rows {
+			if strings.ContainsRune(row, 0) || !utf8.ValidString(row) {
+				t.Fatalf("%s: row not transcoded: %q", tc.name, row)
+			}
+		}
+		if strings.HasPrefix(tc.name, "bom") || tc.name == "plain-le" {
+			if got := strings.Join(rows, ""); got != text {
+				t.Fatalf("%s: rows = %q, want %q", tc.name, got, text)
+			}
+		}
+		if tc.name == "cp1252" && rows[1] != "quote \u201cok\u201d\n" {
+			t.Fatalf("cp1252 row = %q", rows[1])
+		}
+		f.Close()
+	}
+
+	forced, err := OpenWith(context.Background(), filepath.Join(dir, "utf8.log"), OpenOptions{Encoding: EncodingLatin1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer forced.Close()
+	rows, err := forced.LinesSlice(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forced.Encoding != EncodingLatin1 || rows[0] != "caf\u00c3\u00a9\n" {
+		t.Fatalf("forced latin-1 row = %q (%s)", rows[0], forced.Encoding)
+	}
+}
+
+func TestOpenHandlesCRAndMixedLineEndings(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct {
+		name string
+		body string
+		want string
+		rows []string
+	}{
+		{"cr", "one\rtwo\rthree", LineEndingCR, []string{"one\r", "two\r", "three"}},
+		{"mixed", "one\r\ntwo\rthree\nfour\r", LineEndingMixed, []string{"one\r\n", "two\r", "three\n", "four\r"}},
+		{"crlf", "one\r\ntwo\r\n", LineEndingLF, []string{"one\r\n", "two\r\n"}},
+	}
+	for _, tc := range cases {
+		path := filepath.Join(dir, tc.name+".log")
+		if err := os.WriteFile(path, []byte(tc.body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		f, err := Open(context.Background(), path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := f.LinesSlice(0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.LineEnding != tc.want || strings.Join(rows, "|") != strings.Join(tc.rows, "|") {
+			t.Fatalf("%s: ending=%q rows=%q, want %q %q", tc.name, f.LineEnding, rows, tc.want, tc.rows)
+		}
+		var out bytes.Buffer
+		if err := f.WriteRange(&out, 1, len(tc.rows)); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != strings.Join(tc.rows[1:], "") {
+			t.Fatalf("%s: WriteRange = %q, want original bytes", tc.name, out.String())
+		}
+		f.Close()
+	}
+
+	forced, err := OpenWith(context.Background(), filepath.Join(dir, "cr.log"), OpenOptions{LineEnding: LineEndingLF}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer forced.Close()
+	if forced.Lines != 1 {
+		t.Fatalf("forced LF lines = %d, want 1", forced.Lines)
+	}
+
+	utf16CR := append([]byte{0xFF, 0xFE}, encodeUTF16("a\rb\r\nc", false)...)
+	path := filepath.Join(dir, "utf16-cr.log")
+	if err := os.WriteFile(path, utf16CR, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if f.LineEnding != LineEndingMixed || f.Lines != 3 {
+		t.Fatalf("utf-16 mixed: ending=%q lines=%d", f.LineEnding, f.Lines)
+	}
+}
+
+func TestLineReaderKeepsCRLFAcrossBufferBoundary(t *testing.T) {
+	format := lineFormat{enc: EncodingUTF8, ending: LineEndingMixed}
+	body := strings.Repeat("x", 15) + "\r\n" + "y\rz"
+	r := newLineReader(strings.NewReader(body), format, 16)
+	var got []string
+	var line []byte
+	for {
+		part, err := r.ReadSlice()
+		line = append(line, part...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if len(line) > 0 {
+			got = append(got, string(line))
+		}
+		line = nil
+		if err != nil {
+			break
+		}
+	}
+	want := []string{strings.Repeat("x", 15) + "\r\n", "y\r", "z"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("lines = %q, want %q", got, want)
+	}
+}
+
+func TestStreamLineReaderDetectsFormat(t *testing.T) {
+	body := append([]byte{0xFF, 0xFE}, encodeUTF16("one\r\ntwo\rthree", false)...)
+	r := NewStreamLineReader(bytes.NewReader(body), OpenOptions{}, 16)
+	if r.Encoding() != EncodingUTF16LE {
+		t.Fatalf("encoding = %q, want %q", r.Encoding(), EncodingUTF16LE)
+	}
+	var got []string
+	var pos int64
+	for {
+		part, err := r.ReadSlice()
+		if len(part) > 0 {
+			got = append(got, DecodeRow(r.Encoding(), part, pos))
+			pos += int64(len(part))
+		}
+		if err != nil {
+			break
+		}
+	}
+	if want := []string{"one\r\n", "two\r", "three"}; strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("lines = %q, want %q", got, want)
+	}
+}
+
+func TestParallelScanMatchesSequential(t *testing.T) {
+	old := parallelRangeBytes
+	parallelRangeBytes = 1 << 10
+	t.Cleanup(func() { parallelRangeBytes = old })
+
+	cases := map[string][]byte{
+		"synthetic":     syntheticLog(3000),
+		"partial tail":  append(syntheticLog(700), []byte("no newline at end")...),
+		"blank lines":   []byte(strings.Repeat("\n", 5000)),
+		"range aligned": []byte(strings.Repeat(strings.Repeat("x", 1023)+"\n", 40)),
+		"no newlines":   []byte(strings.Repeat("y", 10000)),
+	}
+	for name, body := range cases {
+		t.Run(name, func(t *testing.T) {
+			wantBase, wantLines, wantOK, err := scanLines(bytes.NewReader(body), lineFormat{enc: EncodingUTF8, ending: LineEndingLF}, MaxIndexedBytes, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, workers := range []int{2, 3, 8} {
+				base, lines, ok, err := scanLinesParallel(bytes.NewReader(body), int64(len(body)), workers, MaxIndexedBytes, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok != wantOK || lines != wantLines || base.Len() != wantBase.Len() {
+					t.Fatalf("workers=%d: got %v/%d lines/%d groups, want %v/%d/%d", workers, ok, lines, base.Len(), wantOK, wantLines, wantBase.Len())
+				}
+				for i := 0; i < base.Len(); i++ {
+					if base.At(i) != wantBase.At(i) {
+						t.Fatalf("workers=%d: base[%d] = %d, want %d", workers, i, base.At(i), wantBase.At(i))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParallelScanFallsBackOnLongLine(t *testing.T) {
+	old := parallelRangeBytes
+	parallelRangeBytes = 64 << 10
+	t.Cleanup(func() { parallelRangeBytes = old })
+
+	body := append(syntheticLog(1000), bytes.Repeat([]byte("z"), int(MaxIndexedLineBytes)+1)...)
+	body = append(body, syntheticLog(1000)...)
+	wantBase, wantLines, _, err := scanLines(bytes.NewReader(body), lineFormat{enc: EncodingUTF8, ending: LineEndingLF}, MaxIndexedBytes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, lines, ok, err := scanLinesParallel(bytes.NewReader(body), int64(len(body)), 4, MaxIndexedBytes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || lines != wantLines || base.Len() != wantBase.Len() {
+		t.Fatalf("got %v/%d lines/%d groups, want line mode with %d/%d", ok, lines, base.Len(), wantLines, wantBase.Len())
+	}
+	if wantLines != 2001 {
+		t.Fatalf("sequential lines = %d, want 2001 with the long line split in two", wantLines)
+	}
+}
+
+func TestOpenReportsProgressAndHonorsCancel(t *testing.T) {
+	dir := t.TempDir()
+	body := syntheticLog(20000)
+	plain := filepath.Join(dir, "progress.log")
+	if err := os.WriteFile(plain, body, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	gzPath := filepath.Join(dir, "progress.log.gz")
+	writeGzip(t, gzPath, gzip.DefaultCompression, body)
+
+	for _, path := range []string{plain, gzPath} {
+		var last Progress
+		lf, err := Open(context.Background(), path, func(p Progress) { last = p })
+		if err != nil {
+			t.Fatal(err)
+		}
+		lf.Close()
+		if last.Phase == "" || last.Total <= 0 || last.Bytes <= 0 || last.Bytes > last.Total {
+			t.Fatalf("%s: last progress = %+v", filepath.Base(path), last)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		_, err = Open(ctx, path, func(Progress) {
+			calls++
+			cancel()
+		})
+		if err != context.Canceled {
+			t.Fatalf("%s: err = %v, want context.Canceled", filepath.Base(path), err)
+		}
+		if calls != 1 {
+			t.Fatalf("%s: progress called %d times after cancel, want 1", filepath.Base(path), calls)
+		}
+	}
+}
+
+func BenchmarkIndexPlain(b *testing.B) {
+	size := int64(2 << 30)
+	if v := os.Getenv("BIGLOG_BENCH_BYTES"); v != "" {
+		fmt.Sscanf(v, "%d", &size)
+	}
+	path := filepath.Join(b.TempDir(), "bench.log")
+	handle, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	block := syntheticLog(50000)
+	for written := int64(0); written < size; written += int64(len(block)) {
+		if _, err := handle.Write(block); err != nil {
+			b.Fatal(err)
+		}
+	}
+	info, _ := handle.Stat()
+	size = info.Size()
+
+	for _, workers := range []int{1, 0} {
+		name := "serial"
+		if workers == 0 {
+			name = "parallel"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				if _, _, ok, err := scanLinesParallel(handle, size, workers, MaxIndexedBytes, nil); err != nil || !ok {
+					b.Fatalf("scan failed: ok=%v err=%v", ok, err)
+				}
+			}
+		})
+	}
+	handle.Close()
+}
+
+// BenchmarkLinesSlice reads a line-mode file a Group at a time, as paging
+// through it in the viewer does.
+func BenchmarkLinesSlice(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "bench.log")
+	if err := os.WriteFile(path, syntheticLog(60000), 0o600); err != nil {
+		b.Fatal(err)
+	}
+	f, err := Open(context.Background(), path, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	b.SetBytes(f.Size)
+	for i := 0; i < b.N; i++ {
+		for start := 0; start < f.Lines; start += Group {
+			if _, err := f.LinesSlice(start, Group); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkLinesSliceGzip(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "bench.log.gz")
+	writeGzip(b, path, gzip.DefaultCompression, syntheticLog(60000))
+	f, err := Open(context.Background(), path, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	b.SetBytes(f.Size)
+	// The viewer pages by its own row count, so pages start mid-group.
+	for i := 0; i < b.N; i++ {
+		for start := 0; start < f.Lines; start += 400 {
+			if _, err := f.LinesSlice(start, 400); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestRecordsGroupContinuationLinesAndFollowGrowth(t *testing.T) {
+	src := NewMemorySource("app.log", []byte("preamble\n"+
+		"2026-03-04T05:06:07 first\n  detail a\n  detail b\n"+
+		"[2026/03/04 05:06:08] second\n"+
+		"2026-03-04 05:06:09 third\n  partial"))
+	f, err := OpenSource(context.Background(), src, OpenOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	recs, err := f.ScanRecords(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetRecords(recs); err != nil {
+		t.Fatal(err)
+	}
+	if recs.Len() != 4 {
+		t.Fatalf("records = %d, want 4", recs.Len())
+	}
+	if start, end := recs.Span(1); start != 1 || end != 4 {
+		t.Fatalf("span(1) = %d..%d, want 1..4", start, end)
+	}
+	if got := recs.Find(3); got != 1 {
+		t.Fatalf("Find(3) = %d, want 1", got)
+	}
+	slice, err := f.SliceRecords(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slice) != 1 || slice[0].Row != 1 || slice[0].Text != "2026-03-04T05:06:07 first\n  detail a\n  detail b\n" {
+		t.Fatalf("SliceRecords(1, 1) = %+v", slice)
+	}
+
+	src.Append([]byte(" done\n  more\n2026-03-04 05:06:10 fourth\n"))
+	if _, err := f.Extend(); err != nil {
+		t.Fatal(err)
+	}
+	if recs.Len() != 5 {
+		t.Fatalf("records after growth = %d, want 5", recs.Len())
+	}
+	var out bytes.Buffer
+	if err := f.WriteRecords(&out, 3, 4); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "2026-03-04 05:06:09 third\n  partial done\n  more\n" {
+		t.Fatalf("WriteRecords(3, 4) = %q", out.String())
+	}
+}
+
+func TestEachRecordCapsRecordText(t *testing.T) {
+	old := MaxRecordBytes
+	MaxRecordBytes = 38
+	t.Cleanup(func() { MaxRecordBytes = old })
+
+	src := NewMemorySource("app.log", []byte("2026-03-04T05:06:07 first\n  détail détail détail\n  more\n"+
+		"2026-03-04T05:06:08 second\n"))
+	f, err := OpenSource(context.Background(), src, OpenOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	recs, err := f.ScanRecords(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetRecords(recs); err != nil {
+		t.Fatal(err)
+	}
+	slice, err := f.SliceRecords(0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The cut backs off to the start of the "é" that straddles the cap.
+	if len(slice) != 2 || !slice[0].Truncated || slice[0].Text != "2026-03-04T05:06:07 first\n  d\u00e9tail d" {
+		t.Fatalf("first record = %+v", slice[0])
+	}
+	if slice[1].Truncated || slice[1].Text != "2026-03-04T05:06:08 second\n" {
+		t.Fatalf("second record = %+v", slice[1])
+	}
+}
+
+func TestOffsetsTruncate(t *testing.T) {
+	var o Offsets
+	for i := 0; i < 3*offsetsBlock; i++ {
+		o.Append(int64(i * 7))
+	}
+	for _, n := range []int{3 * offsetsBlock, 2*offsetsBlock + 5, offsetsBlock, 1, 0} {
+		o.Truncate(n)
+		if o.Len() != n {
+			t.Fatalf("Truncate(%d): len = %d", n, o.Len())
+		}
+		o.Append(int64(n * 7))
+		if got := o.At(n); got != int64(n*7) {
+			t.Fatalf("after Truncate(%d): At(%d) = %d", n, n, got)
+		}
+		if n > 0 && o.At(n-1) != int64((n-1)*7) {
+			t.Fatalf("after Truncate(%d): At(%d) = %d", n, n-1, o.At(n-1))
+		}
+		o.Truncate(n)
+	}
+}
+
+func TestParseTimestampFormats(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{`<font color="blue">2025/10/06 15:29:20.746 INFO x`, time.Date(2025, 10, 6, 15, 29, 20, 746e6, time.Local)},
+		{"2025-10-06T15:29:20Z", time.Date(2025, 10, 6, 15, 29, 20, 0, time.UTC)},
+		{"2025-10-06T15:29:20,5+02:00 y", time.Date(2025, 10, 6, 13, 29, 20, 5e8, time.UTC)},
+		{"2025-10-06 15:40", time.Date(2025, 10, 6, 15, 40, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		got, ok := ParseTimestamp(tt.in)
+		if !ok || !got.Equal(tt.want) {
+			t.Fatalf("ParseTimestamp(%q) = %v, %v; want %v", tt.in, got, ok, tt.want)
+		}
+	}
+	for _, in := range []string{"no time here", "2025/13/06 15:29:20", "2025/10/06 25:00"} {
+		if _, ok := ParseTimestamp(in); ok {
+			t.Fatalf("ParseTimestamp(%q) matched", in)
+		}
+	}
+}
+
+func TestSeekTimeInLineAndByteMode(t *testing.T) {
+	old := MaxIndexedBytes
+	t.Cleanup(func() { MaxIndexedBytes = old })
+	base := time.Date(2025, 10, 6, 12, 0, 0, 0, time.Local)
+	var body []byte
+	for i := 0; i < 20000; i++ {
+		body = fmt.Appendf(body, "<font>%s.000 entry %d</font>\n", base.Add(time.Duration(i)*time.Second).Format("2006/01/02 15:04:05"), i)
+		if i%7 == 0 {
+			body = append(body, "\tat trace line without a time\n"...)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "seek.html")
+	if err := os.WriteFile(path, body, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, limit := range []int64{0, 4} {
+		MaxIndexedBytes = limit
+		f, err := Open(context.Background(), path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, sec := range []int{0, 1, 9999, 19999} {
+			want := fmt.Sprintf("entry %d<", sec)
+			off, ts, ok, err := f.SeekTime(base.Add(time.Duration(sec)*time.Second - 300*time.Millisecond))
+			if err != nil || !ok {
+				t.Fatalf("%s: SeekTime(%d) ok=%v err=%v", f.Mode, sec, ok, err)
+			}
+			if !ts.Equal(base.Add(time.Duration(sec) * time.Second)) {
+				t.Fatalf("%s: SeekTime(%d) time = %v", f.Mode, sec, ts)
+			}
+			if !bytes.HasPrefix(body[off:], []byte("<font>")) || !bytes.Contains(body[off:off+64], []byte(want)) {
+				t.Fatalf("%s: SeekTime(%d) offset %d points at %q", f.Mode, sec, off, body[off:off+40])
+			}
+			row, err := f.RowAt(off)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines, err := f.LinesSlice(row, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(lines[0], want) {
+				t.Fatalf("%s: row %d does not hold %q", f.Mode, row, want)
+			}
+		}
+		if _, _, ok, err := f.SeekTime(base.Add(24 * time.Hour)); err != nil || ok {
+			t.Fatalf("%s: seek past the end ok=%v err=%v", f.Mode, ok, err)
+		}
+		f.Close()
 	}
+}
 
-	chunks, err := f.LinesSlice(0, 1)
+func TestLineScanUpgradesByteModeFile(t *testing.T) {
+	old := MaxIndexedBytes
+	t.Cleanup(func() { MaxIndexedBytes = old })
+	MaxIndexedBytes = 64
+	src := NewMemorySource("big.log", []byte(strings.Repeat("row\n", 3*Group)+"tail"))
+	f, err := OpenSource(context.Background(), src, OpenOptions{}, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(chunks) != 1 {
-		t.Fatalf("chunks = %d, want 1", len(chunks))
+	defer f.Close()
+	if f.Mode != ModeByte {
+		t.Fatalf("mode = %q, want byte mode over the limit", f.Mode)
+	}
+
+	scan := f.NewLineScan()
+	var last Progress
+	if err := scan.Run(context.Background(), func(p Progress) { last = p }); err != nil {
+		t.Fatal(err)
+	}
+	if last.Phase != PhaseCounting || last.Bytes != f.Size {
+		t.Fatalf("last progress = %+v", last)
+	}
+	src.Append([]byte(" end\nmore\n"))
+	if _, err := f.Extend(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.ApplyLineScan(scan); err != nil {
+		t.Fatal(err)
+	}
+	if f.Mode != ModeLine || f.Lines != 3*Group+2 {
+		t.Fatalf("after upgrade mode = %q, lines = %d", f.Mode, f.Lines)
 	}
-	if len(chunks[0]) != int(ByteChunkSize) {
-		t.Fatalf("first chunk length = %d, want %d", len(chunks[0]), ByteChunkSize)
+	lines, err := f.LinesSlice(3*Group, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(lines, "") != "tail end\nmore\n" {
+		t.Fatalf("last rows = %q", lines)
+	}
+
+	src.Append([]byte("again\n"))
+	growth, err := f.Extend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if growth.Mode != ModeLine || f.Lines != 3*Group+3 {
+		t.Fatalf("growth past the limit = %+v", growth)
+	}
+}
+
+func TestCheckReportsOnDiskChanges(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "watched.log")
+	write := func(body string, mod time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().Add(-time.Hour)
+	write("one\ntwo\n", start)
+	f, err := Open(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	steps := []struct {
+		name string
+		do   func()
+		want Change
+	}{
+		{"untouched", func() {}, Unchanged},
+		{"touched", func() { write("one\ntwo\n", start.Add(time.Minute)) }, Unchanged},
+		{"appended", func() { write("one\ntwo\nthree\n", start.Add(2*time.Minute)) }, ChangeAppended},
+		{"rewritten", func() { write("ONE\ntwo\nthree\n", start.Add(3*time.Minute)) }, ChangeRewritten},
+		{"truncated", func() { write("one\n", start.Add(4*time.Minute)) }, ChangeTruncated},
+		{"replaced", func() {
+			next := filepath.Join(dir, "next.log")
+			if err := os.WriteFile(next, []byte("one\ntwo\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Rename(next, path); err != nil {
+				t.Fatal(err)
+			}
+		}, ChangeReplaced},
+		{"deleted", func() { os.Remove(path) }, ChangeDeleted},
+	}
+	for _, step := range steps {
+		step.do()
+		got, err := f.Check()
+		if err != nil || got != step.want {
+			t.Fatalf("%s: Check() = %q, %v; want %q", step.name, got, err, step.want)
+		}
+	}
+	if !ChangeRewritten.Stale() || ChangeAppended.Stale() || ChangeReplaced.Stale() {
+		t.Fatal("Stale() disagrees with which changes break the index")
+	}
+}
+
+func TestHasNonPrintable(t *testing.T) {
+	for s, want := range map[string]bool{
+		"plain\ttext\r\n":        false,
+		"\x1b[31mred\x1b[0m":     false,
+		"héllo wörld":            false,
+		"nul \x00 byte":          true,
+		"bell \x07":              true,
+		"bad utf-8 \xff\xfe":     true,
+		"del \x7f":               true,
+		"c1 control \u0085 here": true,
+		"replacement � kept":     false,
+	} {
+		if got := HasNonPrintable(s); got != want {
+			t.Errorf("HasNonPrintable(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestRowOffset(t *testing.T) {
+	body := "one\ntwo\nthree\n"
+	f, err := OpenSource(context.Background(), NewMemorySource("rows.log", []byte(body)), OpenOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for row, want := range []int64{0, 4, 8, 14, 14} {
+		got, err := f.RowOffset(row)
+		if err != nil || got != want {
+			t.Fatalf("RowOffset(%d) = %d, %v; want %d", row, got, err, want)
+		}
 	}
 }
@@ -0,0 +1,245 @@
+package indexer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// LineEndingLF splits lines on "\n" only, which also covers "\r\n". The CR
+// and mixed modes split on "\n", "\r\n" and a bare "\r" alike; they differ
+// only in what detection saw, so the UI can tell users what the file uses.
+const (
+	LineEndingLF    = "lf"
+	LineEndingCR    = "cr"
+	LineEndingMixed = "mixed"
+)
+
+func ParseLineEnding(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "auto":
+		return "", nil
+	case "lf", "crlf", "\\n", "\\r\\n":
+		return LineEndingLF, nil
+	case "cr", "\\r":
+		return LineEndingCR, nil
+	case "mixed", "any":
+		return LineEndingMixed, nil
+	}
+	return "", fmt.Errorf("unsupported line ending %q", name)
+}
+
+// DetectLineEnding looks for bare carriage returns in a sample of text in
+// enc. Without any the file is LF; with no line feeds at all it is CR.
+func DetectLineEnding(sample []byte, enc string) string {
+	lf := lineFormat{enc: enc, ending: LineEndingMixed}
+	u := int(lf.unit())
+	var feeds, bareCR int
+	for i := 0; i+u <= len(sample); i += u {
+		switch lf.unitAt(sample, i) {
+		case '\n':
+			feeds++
+		case '\r':
+			if i+2*u <= len(sample) && lf.unitAt(sample, i+u) != '\n' {
+				bareCR++
+			}
+		}
+	}
+	switch {
+	case bareCR == 0:
+		return LineEndingLF
+	case feeds == 0:
+		return LineEndingCR
+	default:
+		return LineEndingMixed
+	}
+}
+
+// lineFormat knows where lines end for one encoding and line ending mode.
+// Every slice it is given must start on a code unit boundary.
+type lineFormat struct {
+	enc    string
+	ending string
+}
+
+func (lf *File) format() lineFormat {
+	return lineFormat{enc: lf.Encoding, ending: lf.LineEnding}
+}
+
+func (f lineFormat) unit() int64 {
+	return unitBytes(f.enc)
+}
+
+func (f lineFormat) bareCR() bool {
+	return f.ending == LineEndingCR || f.ending == LineEndingMixed
+}
+
+func (f lineFormat) unitAt(b []byte, i int) uint16 {
+	switch f.enc {
+	case EncodingUTF16LE:
+		return uint16(b[i]) | uint16(b[i+1])<<8
+	case EncodingUTF16BE:
+		return uint16(b[i])<<8 | uint16(b[i+1])
+	}
+	return uint16(b[i])
+}
+
+// end returns the index just past the first line terminator in b, or -1.
+// A "\r" in the last unit of b could still be the start of "\r\n", so it
+// only counts when eof says nothing follows b.
+func (f lineFormat) end(b []byte, eof bool) int {
+	u := int(f.unit())
+	if u == 1 && !f.bareCR() {
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			return i + 1
+		}
+		return -1
+	}
+	for i := 0; i+u <= len(b); i += u {
+		if u == 1 {
+			k := bytes.IndexAny(b[i:], "\r\n")
+			if k < 0 {
+				return -1
+			}
+			i += k
+		}
+		switch f.unitAt(b, i) {
+		case '\n':
+			return i + u
+		case '\r':
+			if !f.bareCR() {
+				continue
+			}
+			if i+2*u <= len(b) {
+				if f.unitAt(b, i+u) == '\n' {
+					return i + 2*u
+				}
+				return i + u
+			}
+			if eof {
+				return i + u
+			}
+			return -1
+		}
+	}
+	return -1
+}
+
+// safe returns how much of b, which holds no terminator, can be consumed
+// without splitting a "\r\n" pair or a code unit.
+func (f lineFormat) safe(b []byte, eof bool) int {
+	u := int(f.unit())
+	n := len(b) - len(b)%u
+	if !eof && f.bareCR() && n >= u && f.unitAt(b, n-u) == '\r' {
+		n -= u
+	}
+	return n
+}
+
+// last returns the index just past the last line terminator in b, or -1.
+func (f lineFormat) last(b []byte) int {
+	u := int(f.unit())
+	if u == 1 && !f.bareCR() {
+		if i := bytes.LastIndexByte(b, '\n'); i >= 0 {
+			return i + 1
+		}
+		return -1
+	}
+	for i := len(b) - len(b)%u - u; i >= 0; i -= u {
+		switch f.unitAt(b, i) {
+		case '\n':
+			return i + u
+		case '\r':
+			if f.bareCR() {
+				return i + u
+			}
+		}
+	}
+	return -1
+}
+
+// tail reports the terminator character b ends with, or 0.
+func (f lineFormat) tail(b []byte) uint16 {
+	u := int(f.unit())
+	if len(b) < u {
+		return 0
+	}
+	switch c := f.unitAt(b, len(b)-len(b)%u-u); c {
+	case '\n':
+		return c
+	case '\r':
+		if f.bareCR() {
+			return c
+		}
+	}
+	return 0
+}
+
+// LastLineEnd returns the index just past the last line terminator in b, or
+// -1. b must start on a code unit boundary.
+func (lf *File) LastLineEnd(b []byte) int {
+	return lf.format().last(b)
+}
+
+// LineReader splits a stream into lines the way the index does, so callers
+// reading raw bytes agree with it on UTF-16 and CR-terminated files.
+type LineReader struct {
+	r      *bufio.Reader
+	format lineFormat
+}
+
+func (lf *File) NewLineReader(r io.Reader, size int) *LineReader {
+	return newLineReader(r, lf.format(), size)
+}
+
+func newLineReader(r io.Reader, format lineFormat, size int) *LineReader {
+	return &LineReader{r: bufio.NewReaderSize(r, size), format: format}
+}
+
+// NewStreamLineReader reads a stream from its start, such as a decompressed
+// .gz, without an index behind it. The encoding and line ending come from
+// opts, or are detected from the stream's first bytes as Open would.
+func NewStreamLineReader(r io.Reader, opts OpenOptions, size int) *LineReader {
+	br := bufio.NewReaderSize(r, max(size, encodingSampleBytes))
+	sample, _ := br.Peek(encodingSampleBytes)
+	return &LineReader{r: br, format: detectFormat(sample, opts)}
+}
+
+// Encoding is the encoding the stream is read in.
+func (lr *LineReader) Encoding() string {
+	return lr.format.enc
+}
+
+// ReadSlice works like bufio.Reader.ReadSlice('\n'), including returning
+// bufio.ErrBufferFull for lines longer than the buffer. It only asks r for
+// more than it holds when no terminator is in sight, so r refills once per
+// buffer rather than sliding its contents along for every line.
+func (lr *LineReader) ReadSlice() ([]byte, error) {
+	if lr.format.unit() == 1 && !lr.format.bareCR() {
+		return lr.r.ReadSlice('\n')
+	}
+	need := 1
+	for {
+		buf, err := lr.r.Peek(max(lr.r.Buffered(), need))
+		eof := err != nil && err != bufio.ErrBufferFull
+		if end := lr.format.end(buf, eof); end >= 0 {
+			_, _ = lr.r.Discard(end)
+			return buf[:end], nil
+		}
+		if eof {
+			if len(buf) == 0 {
+				return nil, err
+			}
+			_, _ = lr.r.Discard(len(buf))
+			return buf, err
+		}
+		if len(buf) == lr.r.Size() {
+			n := lr.format.safe(buf, false)
+			_, _ = lr.r.Discard(n)
+			return buf[:n], bufio.ErrBufferFull
+		}
+		need = len(buf) + 1
+	}
+}
@@ -0,0 +1,77 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+const PhaseCounting = "counting"
+
+var errStaleLineScan = errors.New("line scan no longer matches the file")
+
+// LineScan builds a line index for a byte-mode File in the background. It
+// copies what it needs from the File up front, so Run touches no File
+// fields and the byte-mode view stays usable while it runs.
+type LineScan struct {
+	src        Source
+	size       int64
+	format     lineFormat
+	compressed bool
+
+	base  Offsets
+	lines int
+	done  bool
+}
+
+// NewLineScan must be called while lf can't change, such as under the lock
+// that guards it.
+func (lf *File) NewLineScan() *LineScan {
+	return &LineScan{src: lf.Src, size: lf.Size, format: lf.format(), compressed: lf.Compressed}
+}
+
+// Run indexes the file by line, ignoring MaxIndexedBytes.
+func (s *LineScan) Run(ctx context.Context, onProgress ProgressFunc) error {
+	rep := newProgress(ctx, onProgress, PhaseCounting, s.size)
+	var base Offsets
+	var n int
+	var err error
+	// The gzip reader serves one cursor at a time, so only plain files
+	// gain from reading ranges in parallel.
+	if s.compressed || s.format.unit() != 1 || s.format.bareCR() {
+		base, n, _, err = scanLines(io.NewSectionReader(s.src, 0, s.size), s.format, 0, rep.update)
+	} else {
+		base, n, _, err = scanLinesParallel(s.src, s.size, 0, 0, rep)
+	}
+	if err != nil {
+		return err
+	}
+	s.base, s.lines, s.done = base, n, true
+	return nil
+}
+
+// ApplyLineScan switches lf to line mode using a finished scan, indexing
+// whatever was appended since the scan started. The file then stays in line
+// mode however large it grows.
+func (lf *File) ApplyLineScan(s *LineScan) error {
+	if !s.done || lf.Src != s.src || lf.Size < s.size {
+		return errStaleLineScan
+	}
+	if lf.Mode != ModeByte {
+		return nil
+	}
+	size := lf.Size
+	lf.Base = s.base
+	lf.Lines = s.lines
+	lf.Size = s.size
+	lf.Mode = ModeLine
+	lf.ChunkSize = 0
+	lf.unlimited = true
+	if size > s.size {
+		if _, err := lf.extendLines(size); err != nil {
+			return err
+		}
+		lf.remember()
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package indexer
+
+import "encoding/binary"
+
+const offsetsBlock = 64
+
+// Offsets stores an ascending list of byte offsets as varint deltas, with an
+// absolute anchor every offsetsBlock entries so lookups stay cheap.
+type Offsets struct {
+	anchors []int64
+	starts  []int
+	data    []byte
+	n       int
+	last    int64
+}
+
+func NewOffsets(values ...int64) Offsets {
+	var o Offsets
+	for _, v := range values {
+		o.Append(v)
+	}
+	return o
+}
+
+func (o *Offsets) Len() int {
+	return o.n
+}
+
+func (o *Offsets) Append(v int64) {
+	if o.n%offsetsBlock == 0 {
+		o.anchors = append(o.anchors, v)
+		o.starts = append(o.starts, len(o.data))
+	} else {
+		o.data = binary.AppendUvarint(o.data, uint64(v-o.last))
+	}
+	o.last = v
+	o.n++
+}
+
+func (o *Offsets) At(i int) int64 {
+	b := i / offsetsBlock
+	v := o.anchors[b]
+	pos := o.starts[b]
+	for k := b * offsetsBlock; k < i; k++ {
+		delta, n := binary.Uvarint(o.data[pos:])
+		pos += n
+		v += int64(delta)
+	}
+	return v
+}
+
+// Truncate drops every value from index n on.
+func (o *Offsets) Truncate(n int) {
+	if n >= o.n {
+		return
+	}
+	if n <= 0 {
+		*o = Offsets{}
+		return
+	}
+	b := (n - 1) / offsetsBlock
+	v := o.anchors[b]
+	pos := o.starts[b]
+	for k := b*offsetsBlock + 1; k < n; k++ {
+		delta, m := binary.Uvarint(o.data[pos:])
+		pos += m
+		v += int64(delta)
+	}
+	o.anchors = o.anchors[:b+1]
+	o.starts = o.starts[:b+1]
+	o.data = o.data[:pos]
+	o.n = n
+	o.last = v
+}
+
+func (o *Offsets) Slice(n int) Offsets {
+	var out Offsets
+	for i := 0; i < n && i < o.n; i++ {
+		out.Append(o.At(i))
+	}
+	return out
+}
+
+func (o *Offsets) Bytes() int64 {
+	return int64(len(o.anchors))*8 + int64(len(o.starts))*8 + int64(len(o.data))
+}
@@ -0,0 +1,231 @@
+package indexer
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"sync"
+)
+
+var parallelRangeBytes int64 = 4 << 20
+
+type rangeScan struct {
+	newlines int
+	head     int64
+	tail     int64
+	longest  int64
+	starts   []int64
+	err      error
+	done     bool
+}
+
+// scanLinesParallel splits src into fixed byte ranges that are read and
+// scanned for newlines concurrently. Ranges are counted first so each one
+// knows its starting line number, then they record the Group-aligned line
+// starts that scanLines would have produced. Ranges cannot know where an
+// over-long line gets split without the rows before them, so meeting one
+// hands the whole file to the sequential scanner instead.
+func scanLinesParallel(src io.ReaderAt, size int64, workers int, maxBytes int64, rep *progress) (Offsets, int, bool, error) {
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	sequential := func() (Offsets, int, bool, error) {
+		rep.restart()
+		return scanLines(io.NewSectionReader(src, 0, size), lineFormat{enc: EncodingUTF8, ending: LineEndingLF}, maxBytes, rep.update)
+	}
+	if workers == 1 || size < 2*parallelRangeBytes {
+		return sequential()
+	}
+
+	n := int((size + parallelRangeBytes - 1) / parallelRangeBytes)
+	results := make([]rangeScan, n)
+	prefix := make([]int, n+1)
+	counted := make([]bool, n)
+	ready := 0
+	aborted := false
+	var mu sync.Mutex
+	cond := sync.NewCond(&mu)
+
+	abort := func() {
+		mu.Lock()
+		aborted = true
+		cond.Broadcast()
+		mu.Unlock()
+	}
+
+	pool := sync.Pool{New: func() any {
+		buf := make([]byte, parallelRangeBytes)
+		return &buf
+	}}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	work := func(i int) {
+		start := int64(i) * parallelRangeBytes
+		end := start + parallelRangeBytes
+		if end > size {
+			end = size
+		}
+		bufp := pool.Get().(*[]byte)
+		defer pool.Put(bufp)
+		buf := (*bufp)[:end-start]
+
+		res := rangeScan{}
+		if _, err := src.ReadAt(buf, start); err != nil && err != io.EOF {
+			res.err = err
+		} else {
+			countRange(buf, &res)
+		}
+
+		mu.Lock()
+		results[i].newlines = res.newlines
+		results[i].err = res.err
+		counted[i] = true
+		for ready < n && counted[ready] {
+			prefix[ready+1] = prefix[ready] + results[ready].newlines
+			ready++
+		}
+		cond.Broadcast()
+		for ready < i && !aborted {
+			cond.Wait()
+		}
+		first := prefix[i]
+		stop := aborted || res.err != nil
+		mu.Unlock()
+
+		if !stop {
+			res.starts = rangeStarts(buf, start, size, first)
+		}
+
+		mu.Lock()
+		results[i].head = res.head
+		results[i].tail = res.tail
+		results[i].longest = res.longest
+		results[i].starts = res.starts
+		results[i].done = true
+		cond.Broadcast()
+		mu.Unlock()
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				work(i)
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for i := 0; i < n; i++ {
+			mu.Lock()
+			stop := aborted
+			mu.Unlock()
+			if stop {
+				return
+			}
+			jobs <- i
+		}
+	}()
+
+	var base Offsets
+	var carry int64
+	split := false
+	var scanErr error
+	if size > 0 {
+		base.Append(0)
+	}
+	for i := 0; i < n && !split && scanErr == nil; i++ {
+		mu.Lock()
+		for !results[i].done && !aborted {
+			cond.Wait()
+		}
+		res := results[i]
+		results[i].starts = nil
+		mu.Unlock()
+		if res.err != nil {
+			scanErr = res.err
+			break
+		}
+		if res.newlines == 0 {
+			carry += res.head
+		} else {
+			if carry+res.head > MaxIndexedLineBytes || res.longest > MaxIndexedLineBytes {
+				split = true
+				break
+			}
+			carry = res.tail
+		}
+		if carry > MaxIndexedLineBytes {
+			split = true
+			break
+		}
+		for _, off := range res.starts {
+			base.Append(off)
+		}
+		if err := rep.update(int64(i+1)*parallelRangeBytes, prefix[i+1]); err != nil {
+			scanErr = err
+		}
+	}
+	if split || scanErr != nil {
+		abort()
+	}
+	wg.Wait()
+	if scanErr != nil {
+		return Offsets{}, 0, false, scanErr
+	}
+	if split {
+		return sequential()
+	}
+	lines := prefix[n]
+	if carry > 0 {
+		lines++
+	}
+	return base, lines, true, nil
+}
+
+// countRange counts newlines and measures the line fragments at both ends of
+// buf plus the longest line that starts and ends inside it.
+func countRange(buf []byte, res *rangeScan) {
+	first := bytes.IndexByte(buf, '\n')
+	if first < 0 {
+		res.head = int64(len(buf))
+		return
+	}
+	last := bytes.LastIndexByte(buf, '\n')
+	res.head = int64(first + 1)
+	res.tail = int64(len(buf) - last - 1)
+	res.newlines = 1
+	prev := first
+	for prev < last {
+		next := bytes.IndexByte(buf[prev+1:], '\n') + prev + 1
+		if l := int64(next - prev); l > res.longest {
+			res.longest = l
+		}
+		res.newlines++
+		prev = next
+	}
+}
+
+// rangeStarts returns the offsets of line starts inside buf whose global line
+// number is a multiple of Group, given the count of newlines before buf.
+func rangeStarts(buf []byte, start, size int64, before int) []int64 {
+	skip := (Group - (before+1)%Group) % Group
+	var out []int64
+	j := 0
+	pos := 0
+	for {
+		k := bytes.IndexByte(buf[pos:], '\n')
+		if k < 0 {
+			return out
+		}
+		pos += k + 1
+		if j >= skip && (j-skip)%Group == 0 {
+			if off := start + int64(pos); off < size {
+				out = append(out, off)
+			}
+		}
+		j++
+	}
+}
@@ -0,0 +1,62 @@
+package indexer
+
+import "context"
+
+const progressStep int64 = 8 << 20
+
+const (
+	PhaseIndexing      = "indexing"
+	PhaseDecompressing = "decompressing"
+)
+
+type Progress struct {
+	Phase string
+	Bytes int64
+	Total int64
+	Lines int
+}
+
+type ProgressFunc func(Progress)
+
+// progress throttles ProgressFunc calls to one per progressStep bytes and
+// is also where long scans notice that their context was cancelled.
+type progress struct {
+	ctx   context.Context
+	fn    ProgressFunc
+	phase string
+	total int64
+	next  int64
+}
+
+func newProgress(ctx context.Context, fn ProgressFunc, phase string, total int64) *progress {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &progress{ctx: ctx, fn: fn, phase: phase, total: total}
+}
+
+func (p *progress) update(bytes int64, lines int) error {
+	if p == nil {
+		return nil
+	}
+	if p.total > 0 && bytes > p.total {
+		bytes = p.total
+	}
+	if bytes < p.next && bytes < p.total {
+		return nil
+	}
+	p.next = bytes + progressStep
+	if err := p.ctx.Err(); err != nil {
+		return err
+	}
+	if p.fn != nil {
+		p.fn(Progress{Phase: p.phase, Bytes: bytes, Total: p.total, Lines: lines})
+	}
+	return nil
+}
+
+func (p *progress) restart() {
+	if p != nil {
+		p.next = 0
+	}
+}
@@ -0,0 +1,237 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultRecordPattern matches rows that begin with a date and time, which
+// is how most log entries start.
+const DefaultRecordPattern = `^\s*[\[(]?\d{4}[-/.]\d{2}[-/.]\d{2}[T ]\d{2}:\d{2}`
+
+// MaxRecordRows caps a record so a pattern that stops matching can't turn
+// the rest of the file into one giant record.
+const MaxRecordRows = 4096
+
+// MaxRecordBytes caps the text EachRecord builds for one record, as rows of
+// up to MaxIndexedLineBytes could otherwise add up to gigabytes.
+var MaxRecordBytes = 64 << 20
+
+var ErrNoRecords = errors.New("record mode is not enabled")
+
+// Records groups rows into logical records, such as a log entry followed by
+// its stack trace. A record starts at row 0 and at every row that matches
+// the pattern or follows MaxRecordRows rows of the current record, except
+// rows that continue a split line.
+type Records struct {
+	Pattern string
+
+	re     *regexp.Regexp
+	starts Offsets
+	rows   int
+}
+
+// Record is one record's text and the row it starts at. Truncated is set
+// when the text was cut at MaxRecordBytes.
+type Record struct {
+	Row       int
+	Text      string
+	Truncated bool
+}
+
+// ScanRecords builds a record index over lf without changing lf, so it can
+// run under a read lock. Install the result with SetRecords.
+func (lf *File) ScanRecords(ctx context.Context, pattern string) (*Records, error) {
+	if lf.Mode != ModeLine {
+		return nil, errors.New("record mode needs a line-indexed file")
+	}
+	if pattern == "" {
+		pattern = DefaultRecordPattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid record pattern: %w", err)
+	}
+	r := &Records{Pattern: pattern, re: re}
+	if err := lf.scanRecords(ctx, r, 0); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetRecords installs r, catching it up with rows added since it was
+// scanned. A nil r turns record mode off.
+func (lf *File) SetRecords(r *Records) error {
+	if r != nil && r.rows != lf.Lines {
+		if err := lf.scanRecords(context.Background(), r, r.rows-1); err != nil {
+			return err
+		}
+	}
+	lf.Records = r
+	return nil
+}
+
+// scanRecords rescans rows from onward, dropping any record starts found
+// there before.
+func (lf *File) scanRecords(ctx context.Context, r *Records, from int) error {
+	if from < 0 {
+		from = 0
+	}
+	keep, last := r.keep(from)
+	r.starts.Truncate(keep)
+	err := lf.recordStarts(ctx, r.re, from, lf.Lines, last, 0, func(row int) {
+		r.starts.Append(int64(row))
+	})
+	if err != nil {
+		return err
+	}
+	r.rows = lf.Lines
+	return nil
+}
+
+// keep returns how many record starts precede row from, and the row the
+// last of them is at, or -1.
+func (r *Records) keep(from int) (int, int) {
+	n := r.Find(from-1) + 1
+	if n == 0 {
+		return 0, -1
+	}
+	return n, int(r.starts.At(n - 1))
+}
+
+// recordStarts calls add with each of rows from..end-1 that starts a
+// record, given last, the row the record open before from starts at, or -1.
+// Row numbers are lf's own plus shift.
+func (lf *File) recordStarts(ctx context.Context, re *regexp.Regexp, from, end, last, shift int, add func(row int)) error {
+	return lf.eachRow(from-shift, end-shift, func(i int, _ int64, row []byte, continued bool) error {
+		i += shift
+		if i%Group == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		start := last < 0
+		if !start && !continued {
+			start = i-last >= MaxRecordRows || re.MatchString(strings.TrimRight(DecodeText(lf.Encoding, row), "\r\n"))
+		}
+		if start {
+			add(i)
+			last = i
+		}
+		return nil
+	})
+}
+
+func (r *Records) Len() int {
+	return r.starts.Len()
+}
+
+// Span returns the rows start..end-1 that record i covers.
+func (r *Records) Span(i int) (int, int) {
+	start := int(r.starts.At(i))
+	if i+1 < r.starts.Len() {
+		return start, int(r.starts.At(i + 1))
+	}
+	return start, r.rows
+}
+
+// Find returns the record that row belongs to.
+func (r *Records) Find(row int) int {
+	return sort.Search(r.starts.Len(), func(i int) bool {
+		return int(r.starts.At(i)) > row
+	}) - 1
+}
+
+// EachRecord calls fn with the UTF-8 text of records start..end-1.
+func (lf *File) EachRecord(start, end int, fn func(i int, rec Record) error) error {
+	r := lf.Records
+	if r == nil {
+		return ErrNoRecords
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end > r.Len() {
+		end = r.Len()
+	}
+	if end <= start {
+		return nil
+	}
+	first, next := r.Span(start)
+	_, last := r.Span(end - 1)
+	i := start
+	rec := Record{Row: first}
+	var text strings.Builder
+	err := lf.eachRow(first, last, func(row int, pos int64, b []byte, _ bool) error {
+		if !rec.Truncated {
+			part := DecodeRow(lf.Encoding, b, pos)
+			if room := MaxRecordBytes - text.Len(); len(part) > room {
+				part = cutUTF8(part, room)
+				rec.Truncated = true
+			}
+			text.WriteString(part)
+		}
+		if row+1 < next {
+			return nil
+		}
+		rec.Text = text.String()
+		if err := fn(i, rec); err != nil {
+			return err
+		}
+		text.Reset()
+		rec.Truncated = false
+		i++
+		if i < end {
+			rec.Row, next = r.Span(i)
+		}
+		return nil
+	})
+	if err == errRangeDone {
+		return nil
+	}
+	return err
+}
+
+// SliceRecords is LinesSlice for records.
+func (lf *File) SliceRecords(start, count int) ([]Record, error) {
+	if lf.Records == nil {
+		return nil, ErrNoRecords
+	}
+	if start < 0 || start > lf.Records.Len() {
+		return nil, fmt.Errorf("start out of range")
+	}
+	out := make([]Record, 0, min(count, lf.Records.Len()-start))
+	err := lf.EachRecord(start, start+count, func(_ int, rec Record) error {
+		out = append(out, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// WriteRecords writes records start..end-1 as UTF-8.
+func (lf *File) WriteRecords(w io.Writer, start, end int) error {
+	r := lf.Records
+	if r == nil {
+		return ErrNoRecords
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end > r.Len() {
+		end = r.Len()
+	}
+	if end <= start {
+		return nil
+	}
+	from, _ := r.Span(start)
+	_, to := r.Span(end - 1)
+	return lf.WriteRange(w, from, to)
+}
@@ -0,0 +1,290 @@
+package indexer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Row is one indexed row. Continued is set when the row is a later segment
+// of a line that was longer than MaxIndexedLineBytes.
+type Row struct {
+	Text      string
+	Continued bool
+}
+
+// readRow reads the next row from r: up to and including the next line
+// terminator, or MaxIndexedLineBytes bytes if the line is longer than that.
+// It only looks at what r already holds, so r refills once per buffer
+// instead of sliding its contents along for every row.
+func readRow(r *bufio.Reader, format lineFormat, buf []byte) ([]byte, error) {
+	buf = buf[:0]
+	plain := format.unit() == 1 && !format.bareCR()
+	need := 1
+	for {
+		room := int(MaxIndexedLineBytes) - len(buf)
+		if plain && room >= r.Size() {
+			// A whole buffer fits, so ReadSlice can't run past the cap.
+			piece, err := r.ReadSlice('\n')
+			buf = append(buf, piece...)
+			if err != bufio.ErrBufferFull {
+				return buf, err
+			}
+			continue
+		}
+		chunk, err := r.Peek(min(max(r.Buffered(), need), room))
+		// A row cut at MaxIndexedLineBytes ends there whatever follows.
+		eof := err != nil || len(chunk) == room
+		if end := format.end(chunk, eof); end >= 0 {
+			buf = append(buf, chunk[:end]...)
+			_, _ = r.Discard(end)
+			return buf, nil
+		}
+		n := len(chunk)
+		if !eof {
+			n = format.safe(chunk, false)
+		}
+		buf = append(buf, chunk[:n]...)
+		_, _ = r.Discard(n)
+		if err != nil {
+			return buf, err
+		}
+		if int64(len(buf)) == MaxIndexedLineBytes {
+			return buf, nil
+		}
+		// A held back "\r" or half code unit needs more bytes behind it.
+		need = len(chunk) - n + 1
+	}
+}
+
+// continuedAt reports whether pos falls inside a line rather than at the
+// start of one.
+func (lf *File) continuedAt(pos int64) (bool, error) {
+	format := lf.format()
+	unit := format.unit()
+	if pos < unit {
+		return false, nil
+	}
+	pair := make([]byte, 2*unit)
+	n, err := lf.ReadAt(pair, pos-unit)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	switch format.tail(pair[:unit]) {
+	case '\n':
+		return false, nil
+	case '\r':
+		return n == len(pair) && format.unitAt(pair, int(unit)) == '\n', nil
+	}
+	return true, nil
+}
+
+// AlignOffset rounds off down to the start of a code unit.
+func (lf *File) AlignOffset(off int64) int64 {
+	return off - off%lf.format().unit()
+}
+
+// WriteText writes bytes from..to-1 converted to UTF-8.
+func (lf *File) WriteText(w io.Writer, from, to int64) error {
+	src := io.NewSectionReader(lf, from, to-from)
+	if lf.Encoding == "" || lf.Encoding == EncodingUTF8 {
+		_, err := io.Copy(w, src)
+		return err
+	}
+	buf := make([]byte, 256<<10)
+	carry := 0
+	for {
+		n, err := src.Read(buf[carry:])
+		n += carry
+		keep := 0
+		if err == nil && isUTF16(lf.Encoding) {
+			keep = n % 2
+			if n-keep >= 2 && lf.highSurrogateAt(buf[n-keep-2:]) {
+				keep += 2
+			}
+		}
+		if n > keep {
+			if _, werr := io.WriteString(w, DecodeText(lf.Encoding, buf[:n-keep])); werr != nil {
+				return werr
+			}
+		}
+		carry = copy(buf, buf[n-keep:n])
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (lf *File) highSurrogateAt(b []byte) bool {
+	u := uint16(b[0]) | uint16(b[1])<<8
+	if lf.Encoding == EncodingUTF16BE {
+		u = uint16(b[0])<<8 | uint16(b[1])
+	}
+	return u >= 0xD800 && u < 0xDC00
+}
+
+// eachRow calls fn for rows start..end-1 in line mode. Rows are read from
+// the nearest Group offset, so pos is the byte offset of each row.
+func (lf *File) eachRow(start, end int, fn func(i int, pos int64, row []byte, continued bool) error) error {
+	grp := start / Group
+	if grp >= lf.Base.Len() {
+		if start == 0 && lf.Base.Len() == 0 {
+			return nil
+		}
+		return fmt.Errorf("index out of range")
+	}
+	pos := lf.Base.At(grp)
+	continued, err := lf.continuedAt(pos)
+	if err != nil {
+		return err
+	}
+
+	format := lf.format()
+	r := bufio.NewReaderSize(io.NewSectionReader(lf, pos, math.MaxInt64), lf.rowSpan(pos, end))
+	var buf []byte
+	pendingCR := false
+	for i := grp * Group; i < end; i++ {
+		row, err := readRow(r, format, buf)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if pendingCR {
+			// A row cut between "\r" and "\n" continues into the "\n".
+			continued = len(row) >= int(format.unit()) && format.unitAt(row, 0) == '\n'
+		}
+		if i >= start {
+			if fnErr := fn(i, pos, row, continued); fnErr != nil {
+				return fnErr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		pos += int64(len(row))
+		tail := format.tail(row)
+		continued = tail == 0
+		pendingCR = tail == '\r'
+		buf = row
+	}
+	return nil
+}
+
+// rowSpan sizes the reader for rows from pos through end-1: the bytes up to
+// the Group offset after end, at most 1 MiB. Reading no further than the
+// request keeps a .gz cursor near the next page, which then starts inside
+// the history it retains instead of inflating again from a checkpoint.
+func (lf *File) rowSpan(pos int64, end int) int {
+	stop := lf.Size
+	if grp := (end + Group - 1) / Group; grp < lf.Base.Len() {
+		stop = lf.Base.At(grp)
+	}
+	return int(min(max(stop-pos, 4<<10), 1<<20))
+}
+
+// SliceRows is LinesSlice with the continuation flag of each row.
+func (lf *File) SliceRows(start, count int) ([]Row, error) {
+	if start < 0 || start > lf.Lines {
+		return nil, fmt.Errorf("start out of range")
+	}
+	if start == lf.Lines {
+		return []Row{}, nil
+	}
+	end := start + count
+	if end > lf.Lines {
+		end = lf.Lines
+	}
+	out := make([]Row, end-start)
+	if lf.Mode == ModeByte {
+		lines, err := lf.byteSlice(start, count)
+		if err != nil {
+			return nil, err
+		}
+		for i, text := range lines {
+			out[i].Text = text
+		}
+		return out, nil
+	}
+	err := lf.eachRow(start, end, func(i int, pos int64, row []byte, continued bool) error {
+		out[i-start] = Row{Text: DecodeRow(lf.Encoding, row, pos), Continued: continued}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// EachRow calls fn for rows start..end-1 in order, reading them in one
+// pass rather than a Group at a time. An error from fn stops the walk and
+// is returned.
+func (lf *File) EachRow(start, end int, fn func(i int, row Row) error) error {
+	if start < 0 || start > lf.Lines {
+		return fmt.Errorf("start out of range")
+	}
+	end = min(end, lf.Lines)
+	if start >= end {
+		return nil
+	}
+	if lf.Mode == ModeByte {
+		rows, err := lf.SliceRows(start, end-start)
+		if err != nil {
+			return err
+		}
+		for i, row := range rows {
+			if err := fn(start+i, row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return lf.eachRow(start, end, func(i int, pos int64, row []byte, continued bool) error {
+		return fn(i, Row{Text: DecodeRow(lf.Encoding, row, pos), Continued: continued})
+	})
+}
+
+// LineStart returns the first row of the line that row belongs to, walking
+// back over continuation segments.
+func (lf *File) LineStart(row int) (int, error) {
+	if lf.Mode == ModeByte || row <= 0 || row >= lf.Lines {
+		return row, nil
+	}
+	var pos int64
+	if err := lf.eachRow(row, row+1, func(_ int, p int64, _ []byte, _ bool) error {
+		pos = p
+		return nil
+	}); err != nil {
+		return row, err
+	}
+	for row > 0 {
+		continued, err := lf.continuedAt(pos)
+		if err != nil || !continued {
+			return row, err
+		}
+		pos -= MaxIndexedLineBytes
+		row--
+	}
+	return row, nil
+}
+
+// RowOffset returns the byte offset row starts at, or Size past the last row.
+func (lf *File) RowOffset(row int) (int64, error) {
+	if row <= 0 {
+		return 0, nil
+	}
+	if row >= lf.Lines {
+		return lf.Size, nil
+	}
+	if lf.Mode == ModeByte {
+		return lf.chunkStart(row, make([]byte, lf.ChunkSize))
+	}
+	var pos int64
+	err := lf.eachRow(row, row+1, func(_ int, p int64, _ []byte, _ bool) error {
+		pos = p
+		return nil
+	})
+	return pos, err
+}
@@ -0,0 +1,267 @@
+package indexer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Source is anything a log can be indexed and read from.
+type Source interface {
+	io.ReaderAt
+	io.Closer
+	// ID names the underlying object, such as an absolute path or a URL.
+	// Its extension decides gzip handling and it keys the index cache.
+	ID() string
+	Size() int64
+	ModTime() time.Time
+}
+
+// Refresher is implemented by sources that can grow. Refresh re-reads the
+// size and returns ErrFileReplaced if the object shrank or was replaced.
+type Refresher interface {
+	Refresh() error
+}
+
+// Notifier is implemented by sources that can say when they have new data,
+// so followers don't have to poll them.
+type Notifier interface {
+	Changes() <-chan struct{}
+}
+
+// FileSource is a local file.
+type FileSource struct {
+	path string
+	f    *os.File
+
+	mu   sync.Mutex
+	info os.FileInfo
+}
+
+func OpenFileSource(path string) (*FileSource, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(abs)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &FileSource{path: abs, f: f, info: info}, nil
+}
+
+func (s *FileSource) ReadAt(p []byte, off int64) (int, error) { return s.f.ReadAt(p, off) }
+func (s *FileSource) Close() error                            { return s.f.Close() }
+func (s *FileSource) ID() string                              { return s.path }
+
+func (s *FileSource) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.info.Size()
+}
+
+func (s *FileSource) ModTime() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.info.ModTime()
+}
+
+func (s *FileSource) Refresh() error {
+	info, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if info.Size() < s.info.Size() {
+		return ErrFileReplaced
+	}
+	if cur, err := os.Stat(s.path); err != nil || !os.SameFile(cur, info) {
+		return ErrFileReplaced
+	}
+	s.info = info
+	return nil
+}
+
+// MemorySource is an in-memory log, mostly for tests. Append grows it and
+// notifies followers.
+type MemorySource struct {
+	id      string
+	changes chan struct{}
+
+	mu      sync.RWMutex
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemorySource(id string, data []byte) *MemorySource {
+	return &MemorySource{id: id, data: data, modTime: time.Now(), changes: make(chan struct{}, 1)}
+}
+
+func (s *MemorySource) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+	if off >= int64(len(s.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, s.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (s *MemorySource) Close() error { return nil }
+func (s *MemorySource) ID() string   { return s.id }
+
+func (s *MemorySource) Size() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(len(s.data))
+}
+
+func (s *MemorySource) ModTime() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.modTime
+}
+
+func (s *MemorySource) Refresh() error           { return nil }
+func (s *MemorySource) Changes() <-chan struct{} { return s.changes }
+
+func (s *MemorySource) Append(p []byte) {
+	s.mu.Lock()
+	s.data = append(s.data, p...)
+	s.modTime = time.Now()
+	s.mu.Unlock()
+	select {
+	case s.changes <- struct{}{}:
+	default:
+	}
+}
+
+// HTTPSource reads a remote object with HTTP range requests. The server
+// must answer ranged GETs with 206 Partial Content.
+type HTTPSource struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	size    int64
+	modTime time.Time
+}
+
+func NewHTTPSource(client *http.Client, url string) (*HTTPSource, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	s := &HTTPSource{url: url, client: client}
+	if err := s.probe(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// probe fetches the first byte to learn the object's size.
+func (s *HTTPSource) probe() error {
+	resp, err := s.get(0, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	size, err := contentRangeTotal(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return err
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if size < s.size {
+		return ErrFileReplaced
+	}
+	s.size = size
+	s.modTime = modTime
+	return nil
+}
+
+func (s *HTTPSource) get(from, to int64) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", from, to))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("range request for %s: %s", s.url, resp.Status)
+	}
+	return resp, nil
+}
+
+func contentRangeTotal(v string) (int64, error) {
+	i := strings.LastIndexByte(v, '/')
+	if !strings.HasPrefix(v, "bytes ") || i < 0 {
+		return 0, fmt.Errorf("bad Content-Range %q", v)
+	}
+	return strconv.ParseInt(v[i+1:], 10, 64)
+}
+
+func (s *HTTPSource) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+	want := p
+	if remaining := s.Size() - off; int64(len(want)) > remaining {
+		if remaining <= 0 {
+			return 0, io.EOF
+		}
+		want = want[:remaining]
+	}
+	if len(want) == 0 {
+		return 0, nil
+	}
+	resp, err := s.get(off, off+int64(len(want))-1)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	n, err := io.ReadFull(resp.Body, want)
+	if err == io.ErrUnexpectedEOF || (err == nil && len(want) < len(p)) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (s *HTTPSource) Close() error { return nil }
+func (s *HTTPSource) ID() string   { return s.url }
+
+func (s *HTTPSource) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+func (s *HTTPSource) ModTime() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.modTime
+}
+
+func (s *HTTPSource) Refresh() error { return s.probe() }
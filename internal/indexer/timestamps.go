@@ -0,0 +1,225 @@
+package indexer
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// stampHeadBytes is how far into a line a timestamp is looked for, which
+// leaves room for the markup in front of it in HTML logs.
+const stampHeadBytes = 512
+
+// stampProbeBytes is how much text one probe reads looking for a
+// timestamped line; SeekTime stops bisecting at ranges this small.
+const stampProbeBytes int64 = 256 << 10
+
+// stampRe matches "2025/10/06 15:29:20.746" and ISO-8601 style times, with
+// optional seconds, fraction and zone.
+var stampRe = regexp.MustCompile(`(\d{4})[-/.](\d{2})[-/.](\d{2})[T ](\d{2}):(\d{2})(?::(\d{2})(?:[.,](\d{1,9}))?)?(Z|[+-]\d{2}:?\d{2})?`)
+
+// ParseTimestamp returns the first recognized timestamp in s. Times without
+// a zone are taken as local time.
+func ParseTimestamp(s string) (time.Time, bool) {
+	m := stampRe.FindStringSubmatch(s)
+	if m == nil {
+		return time.Time{}, false
+	}
+	num := func(v string) int {
+		n, _ := strconv.Atoi(v)
+		return n
+	}
+	year, month, day := num(m[1]), num(m[2]), num(m[3])
+	hour, minute, sec := num(m[4]), num(m[5]), num(m[6])
+	if month < 1 || month > 12 || day < 1 || day > 31 || hour > 23 || minute > 59 || sec > 60 {
+		return time.Time{}, false
+	}
+	nsec := 0
+	if frac := m[7]; frac != "" {
+		for len(frac) < 9 {
+			frac += "0"
+		}
+		nsec = num(frac)
+	}
+	loc := time.Local
+	switch zone := m[8]; {
+	case zone == "Z":
+		loc = time.UTC
+	case zone != "":
+		offset := num(zone[1:3])*3600 + num(zone[len(zone)-2:])*60
+		if zone[0] == '-' {
+			offset = -offset
+		}
+		loc = time.FixedZone("", offset)
+	}
+	return time.Date(year, time.Month(month), day, hour, minute, sec, nsec, loc), true
+}
+
+// stampIndex is a sparse map from line offsets to their timestamps, filled
+// in as SeekTime probes the file so later seeks start from tighter bounds.
+// Appending to a file never invalidates it.
+type stampIndex struct {
+	mu      sync.Mutex
+	samples []stampSample
+}
+
+type stampSample struct {
+	off int64
+	ts  time.Time
+}
+
+func (s *stampIndex) add(off int64, ts time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := sort.Search(len(s.samples), func(i int) bool { return s.samples[i].off >= off })
+	if i < len(s.samples) && s.samples[i].off == off {
+		return
+	}
+	s.samples = append(s.samples, stampSample{})
+	copy(s.samples[i+1:], s.samples[i:])
+	s.samples[i] = stampSample{off: off, ts: ts}
+}
+
+// bounds narrows lo..hi to lie between the last sample before t and the
+// first sample at or after it.
+func (s *stampIndex) bounds(t time.Time, lo, hi int64) (int64, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sm := range s.samples {
+		if sm.ts.Before(t) {
+			if sm.off >= lo {
+				lo = sm.off + 1
+			}
+		} else if sm.off < hi {
+			hi = sm.off
+			break
+		}
+	}
+	if lo > hi {
+		lo = hi
+	}
+	return lo, hi
+}
+
+// stampAfter finds the first line starting at or after off whose head has a
+// timestamp, reading at most limit bytes. When there is none it returns
+// where the search stopped.
+func (lf *File) stampAfter(off, limit int64) (int64, time.Time, bool, error) {
+	format := lf.format()
+	off = lf.AlignOffset(off)
+	pos, atStart := off, true
+	if off > 0 {
+		// Start one unit back so a line starting exactly at off is found.
+		pos, atStart = off-format.unit(), false
+	}
+	r := newLineReader(io.NewSectionReader(lf, pos, lf.Size-pos), format, 64<<10)
+	for pos-off < limit {
+		b, err := r.ReadSlice()
+		if atStart && len(b) > 0 {
+			head := b[:min(len(b), stampHeadBytes)]
+			if ts, ok := ParseTimestamp(DecodeText(lf.Encoding, head)); ok {
+				return pos, ts, true, nil
+			}
+		}
+		pos += int64(len(b))
+		switch err {
+		case nil:
+			atStart = true
+		case bufio.ErrBufferFull:
+			atStart = false
+		case io.EOF:
+			return pos, time.Time{}, false, nil
+		default:
+			return 0, time.Time{}, false, err
+		}
+	}
+	return pos, time.Time{}, false, nil
+}
+
+// SeekTime returns the byte offset and timestamp of the first line stamped
+// at or after t. It binary searches the file, so it assumes timestamps
+// mostly ascend; lines without one are skipped. ok is false when every
+// timestamp is earlier than t.
+func (lf *File) SeekTime(t time.Time) (int64, time.Time, bool, error) {
+	unit := lf.format().unit()
+	lo, hi := lf.stamps.bounds(t, 0, lf.Size)
+	for hi-lo > stampProbeBytes {
+		mid := lo + (hi-lo)/2
+		p, ts, ok, err := lf.stampAfter(mid, stampProbeBytes)
+		if err != nil {
+			return 0, time.Time{}, false, err
+		}
+		if ok {
+			lf.stamps.add(p, ts)
+		}
+		if !ok || !ts.Before(t) || p >= hi {
+			hi = mid
+		} else {
+			lo = p + unit
+		}
+	}
+
+	// The answer now starts at most a probe past hi if timestamps ascend;
+	// if they don't, keep scanning so the result is still at or after t.
+	for lo < lf.Size {
+		p, ts, ok, err := lf.stampAfter(lo, lf.Size)
+		if err != nil || !ok {
+			return 0, time.Time{}, false, err
+		}
+		if !ts.Before(t) {
+			lf.stamps.add(p, ts)
+			return p, ts, true, nil
+		}
+		lo = p + unit
+	}
+	return 0, time.Time{}, false, nil
+}
+
+// RowAt returns the row containing byte offset off, or Lines at the end of
+// the file.
+func (lf *File) RowAt(off int64) (int, error) {
+	if off >= lf.Size {
+		return lf.Lines, nil
+	}
+	if off <= 0 {
+		return 0, nil
+	}
+	if lf.Mode == ModeByte {
+		scratch := make([]byte, lf.ChunkSize)
+		i := int(off / lf.ChunkSize)
+		for i > 0 {
+			start, err := lf.chunkStart(i, scratch)
+			if err != nil {
+				return 0, err
+			}
+			if start <= off {
+				break
+			}
+			i--
+		}
+		if next, err := lf.chunkStart(i+1, scratch); err == nil && next <= off {
+			i++
+		}
+		return i, nil
+	}
+	g := sort.Search(lf.Base.Len(), func(g int) bool { return lf.Base.At(g) > off }) - 1
+	if g < 0 {
+		return 0, nil
+	}
+	row := lf.Lines
+	err := lf.eachRow(g*Group, lf.Lines, func(i int, pos int64, b []byte, _ bool) error {
+		if off < pos+int64(len(b)) {
+			row = i
+			return errRangeDone
+		}
+		return nil
+	})
+	if err != nil && err != errRangeDone {
+		return 0, err
+	}
+	return row, nil
+}